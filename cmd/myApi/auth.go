@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+)
+
+// newAuthenticator собирает аутентификатор запросов /query из переменной AUTH — списка способов через запятую,
+// которые пробуются по очереди. Пустой AUTH — none: все запросы анонимные.
+func newAuthenticator(st store.Store) (auth.Authenticator, error) {
+	var chain []auth.Authenticator
+	for _, mode := range strings.Split(os.Getenv("AUTH"), ",") {
+		switch strings.TrimSpace(mode) {
+		case "", "none":
+		case "header":
+			// только за прокси, который сам проверяет пользователя и выставляет заголовок
+			chain = append(chain, auth.Header("X-User"))
+		case "apitoken":
+			chain = append(chain, auth.APITokens(st))
+		case "jwt":
+			a, err := jwtFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, a)
+		default:
+			return nil, fmt.Errorf("unknown AUTH mode %q", mode)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return auth.Chain(chain...), nil
+}
+
+// jwtFromEnv настраивает проверку JWT: ключи берутся из JWT_HS256_SECRET, JWT_RS256_PUBLIC_KEY_FILE и JWT_JWKS_FILE
+// (можно из нескольких сразу), ограничения — из JWT_ISSUER, JWT_AUDIENCE, JWT_NAME_CLAIM и JWT_LEEWAY.
+func jwtFromEnv() (*auth.JWT, error) {
+	cfg := auth.JWTConfig{
+		Issuer:    os.Getenv("JWT_ISSUER"),
+		Audience:  os.Getenv("JWT_AUDIENCE"),
+		NameClaim: os.Getenv("JWT_NAME_CLAIM"),
+		Leeway:    30 * time.Second,
+	}
+	if v := os.Getenv("JWT_LEEWAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid JWT_LEEWAY %q", v)
+		}
+		cfg.Leeway = d
+	}
+
+	if v := os.Getenv("JWT_HS256_SECRET"); v != "" {
+		cfg.Keys = append(cfg.Keys, auth.JWK{Secret: []byte(v)})
+	}
+	if path := os.Getenv("JWT_RS256_PUBLIC_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := auth.ParseRSAPublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_RS256_PUBLIC_KEY_FILE %s: %w", path, err)
+		}
+		cfg.Keys = append(cfg.Keys, auth.JWK{Public: pub})
+	}
+	if path := os.Getenv("JWT_JWKS_FILE"); path != "" {
+		keys, err := auth.LoadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Keys = append(cfg.Keys, keys...)
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("AUTH=jwt requires JWT_HS256_SECRET, JWT_RS256_PUBLIC_KEY_FILE or JWT_JWKS_FILE")
+	}
+	return auth.NewJWT(cfg)
+}
+
+// websocketInit проверяет Authorization из connection_init: браузер не может передать заголовок при открытии
+// websocket, поэтому подписки commentAdded аутентифицируются здесь. С неверными учётными данными соединение
+// отклоняется.
+func websocketInit(a auth.Authenticator) transport.WebsocketInitFunc {
+	return func(ctx context.Context, payload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+		ctx, err := auth.WithAuthorization(ctx, a, payload.Authorization())
+		if err != nil {
+			return ctx, nil, err
+		}
+		return ctx, &payload, nil
+	}
+}
+
+// bootstrapAdmins выдаёт роль ADMIN пользователям из ADMIN_USERS (имена через запятую): остальные роли
+// назначают уже они, мутацией setUserRole. Снять роль у такого пользователя можно, только убрав его из списка.
+func bootstrapAdmins(ctx context.Context, st store.Store) error {
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		users, err := st.GetUsers(ctx, []string{store.UserID(name)})
+		if err != nil {
+			return err
+		}
+		if u := users[store.UserID(name)]; u != nil && u.Role == model.RoleAdmin {
+			continue
+		}
+		if _, err := st.SetUserRole(ctx, name, model.RoleAdmin, time.Now().UTC()); err != nil {
+			return fmt.Errorf("admin %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -3,16 +3,18 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph"
-	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/generated"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/logger"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/pubsub"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
@@ -34,33 +36,99 @@ func main() {
 	rootCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	storeType := os.Getenv("STORE")
-	var st store.Store
-	var err error
+	// подкоманды выполняются вместо сервера
+	var cmd string
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+	switch cmd {
+	case "migrate":
+		if err := runMigrate(rootCtx, os.Args[2:]); err != nil {
+			logger.Log.Fatal().Err(err).Msg("migrate failed")
+		}
+		return
+	case "export":
+		if err := runExport(rootCtx, os.Args[2:]); err != nil {
+			logger.Log.Fatal().Err(err).Msg("export failed")
+		}
+		return
+	case "import":
+		if err := runImport(rootCtx, os.Args[2:]); err != nil {
+			logger.Log.Fatal().Err(err).Msg("import failed")
+		}
+		return
+	}
 
-	switch storeType {
-	case "pg":
-		dsn := os.Getenv("POSTGRES_DSN")
-		if dsn == "" {
-			logger.Log.Fatal().Msg("POSTGRES_DSN environment variable not set")
+	st, err := openStore(rootCtx)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Failed to open store")
+	}
+
+	editWindow := 15 * time.Minute
+	if v := os.Getenv("COMMENT_EDIT_WINDOW"); v != "" {
+		editWindow, err = time.ParseDuration(v)
+		if err != nil {
+			logger.Log.Fatal().Err(err).Msg("Invalid COMMENT_EDIT_WINDOW")
+		}
+	}
+
+	maxDepth := 8
+	if v := os.Getenv("MAX_COMMENT_DEPTH"); v != "" {
+		maxDepth, err = strconv.Atoi(v)
+		if err != nil || maxDepth < 0 {
+			logger.Log.Fatal().Err(err).Msg("Invalid MAX_COMMENT_DEPTH")
+		}
+	}
+
+	publishInterval := 30 * time.Second
+	if v := os.Getenv("PUBLISH_INTERVAL"); v != "" {
+		publishInterval, err = time.ParseDuration(v)
+		if err != nil || publishInterval <= 0 {
+			logger.Log.Fatal().Err(err).Msg("Invalid PUBLISH_INTERVAL")
+		}
+	}
+
+	idempotencyTTL := 24 * time.Hour
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		idempotencyTTL, err = time.ParseDuration(v)
+		if err != nil || idempotencyTTL < 0 {
+			logger.Log.Fatal().Err(err).Msg("Invalid IDEMPOTENCY_TTL")
 		}
+	}
+
+	authenticator, err := newAuthenticator(st)
+	if err != nil {
+		logger.Log.Fatal().Err(err).Msg("Invalid AUTH")
+	}
+	if err := bootstrapAdmins(rootCtx, st); err != nil {
+		logger.Log.Fatal().Err(err).Msg("Failed to set up ADMIN_USERS")
+	}
 
-		st, err = store.NewPostgres(dsn)
+	allowUserArgs := false
+	if v := os.Getenv("AUTH_ALLOW_USER_ARGS"); v != "" {
+		allowUserArgs, err = strconv.ParseBool(v)
 		if err != nil {
-			logger.Log.Fatal().Err(err).Msg("Failed to connect to postgres")
+			logger.Log.Fatal().Err(err).Msg("Invalid AUTH_ALLOW_USER_ARGS")
 		}
-	default:
-		st = store.NewMemStore()
 	}
 
 	bus := pubsub.NewMemoryBus()
-	resolvers := &graph.Resolver{Store: st, Bus: bus, Logger: logger.Log}
-	server := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: resolvers}))
+	resolvers := &graph.Resolver{
+		Store:             st,
+		Bus:               bus,
+		Logger:            logger.Log,
+		CommentEditWindow: editWindow,
+		MaxCommentDepth:   maxDepth,
+		IdempotencyTTL:    idempotencyTTL,
+		AllowUserArgs:     allowUserArgs,
+	}
+	server := handler.New(graph.NewExecutableSchema(resolvers))
 
 	server.AddTransport(transport.POST{})
 	server.AddTransport(transport.GET{})
 	server.AddTransport(transport.Websocket{
 		KeepAlivePingInterval: 30 * time.Second,
+		InitFunc:              websocketInit(authenticator),
 		Upgrader: websocket.Upgrader{
 			CheckOrigin:  func(r *http.Request) bool { return true },
 			Subprotocols: []string{"graphql-transport-ws", "graphql-ws"},
@@ -73,7 +141,12 @@ func main() {
 		code := "INTERNAL"
 		msg := e.Error()
 
+		var conflict *store.ConflictError
 		switch {
+		case errors.As(e, &conflict):
+			code = "CONFLICT"
+		case strings.Contains(msg, "auth is required"):
+			code = "UNAUTHENTICATED"
 		case strings.Contains(msg, "forbidden"):
 			code = "FORBIDDEN"
 		case strings.Contains(msg, "not found"):
@@ -91,11 +164,11 @@ func main() {
 	cors := corsMiddleware(os.Getenv("CORS_ORIGINS"))
 	mux := http.NewServeMux()
 	mux.Handle("/", playground.Handler("GraphQL playground", "/query"))
-	mux.Handle("/query", cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/query", cors(auth.Middleware(authenticator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		graph.WithLoaders(st, func(ctx context.Context) {
 			server.ServeHTTP(w, r.WithContext(ctx))
 		})(r.Context())
-	})))
+	}))))
 
 	addr := ":8080"
 	httpSrv := &http.Server{
@@ -114,6 +187,18 @@ func main() {
 		}
 	}()
 
+	publisherDone := make(chan struct{})
+	go func() {
+		defer close(publisherDone)
+		runPublisher(rootCtx, st, publishInterval)
+	}()
+
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		runIdempotencyPurge(rootCtx, st, idempotencyTTL)
+	}()
+
 	<-rootCtx.Done()
 	logger.Log.Info().Msg("shutdown signal received")
 
@@ -126,16 +211,52 @@ func main() {
 		logger.Log.Info().Msg("http server shutdown successfully")
 	}
 
+	<-publisherDone
+	<-purgeDone
 	closeIfNeeded(bus, "subscription bus")
 	closeIfNeeded(st, "store")
 
 	logger.Log.Info().Msg("graceful shutdown complete")
 }
 
+// openStore открывает хранилище, выбранное переменной STORE.
+func openStore(ctx context.Context) (store.Store, error) {
+	switch os.Getenv("STORE") {
+	case "pg":
+		dsn := os.Getenv("POSTGRES_DSN")
+		if dsn == "" {
+			return nil, errors.New("POSTGRES_DSN environment variable not set")
+		}
+		if err := checkSchema(ctx, dsn); err != nil {
+			return nil, fmt.Errorf("database schema is not up to date: %w", err)
+		}
+		return store.NewPostgres(dsn)
+	default:
+		dataDir := os.Getenv("DATA_DIR")
+		if dataDir == "" {
+			return store.NewMemStore(), nil
+		}
+
+		snapshotEvery := 0
+		if v := os.Getenv("SNAPSHOT_EVERY"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid SNAPSHOT_EVERY %q", v)
+			}
+			snapshotEvery = n
+		}
+		st, err := store.OpenMemStore(dataDir, snapshotEvery)
+		if err != nil {
+			return nil, fmt.Errorf("open memory store data dir %s: %w", dataDir, err)
+		}
+		return st, nil
+	}
+}
+
 func closeIfNeeded(x any, name string) {
 	if c, ok := x.(io.Closer); ok && c != nil {
 		if err := c.Close(); err != nil {
-			logger.Log.Error().Str("component", name).Msg("close error")
+			logger.Log.Error().Err(err).Str("component", name).Msg("close error")
 			return
 		}
 		logger.Log.Info().Str("component", name).Msg("closed")
@@ -163,7 +284,7 @@ func corsMiddleware(origins string) func(http.Handler) http.Handler {
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
 			}
 			if r.Method == http.MethodOptions {
-				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-User")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User")
 				w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
 				w.WriteHeader(http.StatusNoContent)
 				return
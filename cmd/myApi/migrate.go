@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/migrate"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/migrations"
+)
+
+const migrateUsage = "usage: myApi migrate up|down|status|to N|force N"
+
+// runMigrate выполняет подкоманду migrate над базой из POSTGRES_DSN.
+func runMigrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		return errors.New("POSTGRES_DSN environment variable not set")
+	}
+	runner, db, err := openMigrations(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		return runner.Up(ctx)
+	case "down":
+		return runner.Down(ctx)
+	case "to", "force":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if args[0] == "force" {
+			return runner.Force(ctx, version)
+		}
+		return runner.To(ctx, version)
+	case "status":
+		st, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d (latest %d)\n", st.Version, st.Latest)
+		if st.Dirty {
+			fmt.Println("dirty: true")
+		}
+		for _, m := range st.Pending {
+			fmt.Printf("pending: %04d_%s\n", m.Version, m.Name)
+		}
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+func openMigrations(dsn string) (*migrate.Runner, *sql.DB, error) {
+	list, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migrate.New(db, list), db, nil
+}
+
+// checkSchema не даёт серверу стартовать на схеме старее, чем ожидает бинарник.
+func checkSchema(ctx context.Context, dsn string) error {
+	runner, db, err := openMigrations(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	st, err := runner.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.Dirty {
+		return fmt.Errorf("%w (version %d)", migrate.ErrDirty, st.Version)
+	}
+	if st.Version < st.Latest {
+		return fmt.Errorf("schema version %d is behind %d, run `myApi migrate up`", st.Version, st.Latest)
+	}
+	return nil
+}
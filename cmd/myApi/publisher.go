@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/logger"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
+)
+
+// runPublisher раз в interval публикует отложенные посты, время которых наступило, пока не отменён ctx.
+func runPublisher(ctx context.Context, st store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := st.PublishDue(ctx, time.Now().UTC())
+		if err != nil && ctx.Err() == nil {
+			logger.Log.Error().Err(err).Msg("publish scheduled posts")
+		}
+		for _, p := range published {
+			logger.Log.Info().Str("post_id", p.ID).Msg("scheduled post published")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runIdempotencyPurge удаляет ключи идемпотентности старше ttl, пока не отменён ctx. При ttl = 0 ключи не истекают.
+func runIdempotencyPurge(ctx context.Context, st store.Store, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	ticker := time.NewTicker(min(ttl, time.Hour))
+	defer ticker.Stop()
+
+	for {
+		n, err := st.PurgeIdempotencyKeys(ctx, time.Now().UTC().Add(-ttl))
+		if err != nil && ctx.Err() == nil {
+			logger.Log.Error().Err(err).Msg("purge idempotency keys")
+		}
+		if n > 0 {
+			logger.Log.Info().Int("count", n).Msg("expired idempotency keys purged")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
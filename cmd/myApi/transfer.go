@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/logger"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
+)
+
+// importBatchSize — сколько строк файла уходит в хранилище за один вызов Import.
+const importBatchSize = 500
+
+// runExport выгружает посты и комментарии хранилища в NDJSON-файл.
+func runExport(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: myApi export FILE")
+	}
+
+	st, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(st, "store")
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	n := 0
+	err = st.Export(ctx, func(rec store.Record) error {
+		n++
+		return enc.Encode(rec)
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	logger.Log.Info().Int("records", n).Str("file", args[0]).Msg("export done")
+	return nil
+}
+
+// runImport загружает NDJSON-файл батчами. Уже существующие записи пропускаются,
+// поэтому прерванный импорт можно просто запустить заново.
+func runImport(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: myApi import FILE")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := openStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(st, "store")
+
+	var (
+		batch       []store.Record
+		read, added int
+		line        int
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := st.Import(ctx, batch)
+		added += n
+		if err != nil {
+			return fmt.Errorf("batch ending at line %d: %w", line, err)
+		}
+		logger.Log.Info().Int("read", read).Int("added", added).Msg("import progress")
+		batch = batch[:0]
+		return nil
+	}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec store.Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		batch = append(batch, rec)
+		read++
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	logger.Log.Info().Int("read", read).Int("added", added).Str("file", args[0]).Msg("import done")
+	return nil
+}
@@ -2,9 +2,11 @@ package graph
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
 )
 
@@ -12,18 +14,27 @@ type ctxKey int
 
 const loadersKey ctxKey = 1
 
+const (
+	loaderDelay    = 2 * time.Millisecond
+	loaderMaxBatch = 512
+)
+
 type Loaders struct {
-	CommentsCount *CommentsCountLoader
+	Reactions *ReactionsLoader
+	PostTags  *PostTagsLoader
+	Posts     *PostLoader
+	Comments  *CommentLoader
+	Users     *UserLoader
 }
 
 func WithLoaders(st store.Store, next func(ctx context.Context)) func(ctx context.Context) {
 	return func(ctx context.Context) {
 		loaders := &Loaders{
-			CommentsCount: NewCommentsCountLoader(
-				st,
-				2*time.Millisecond,
-				512,
-			),
+			Reactions: NewReactionsLoader(st, loaderDelay, loaderMaxBatch),
+			PostTags:  NewPostTagsLoader(st, loaderDelay, loaderMaxBatch),
+			Posts:     NewPostLoader(st, loaderDelay, loaderMaxBatch),
+			Comments:  NewCommentLoader(st, loaderDelay, loaderMaxBatch),
+			Users:     NewUserLoader(st, loaderDelay, loaderMaxBatch),
 		}
 		ctx = context.WithValue(ctx, loadersKey, loaders)
 		next(ctx)
@@ -39,46 +50,102 @@ func GetLoaders(ctx context.Context) *Loaders {
 	return nil
 }
 
-type CommentsCountLoader struct {
-	st       store.Store
+// Loader собирает ключи, запрошенные за delay, и загружает их одним батчем.
+type Loader[V any] struct {
+	fetch    func(ctx context.Context, keys []string) (map[string]V, error)
 	mu       sync.Mutex
-	cache    map[string]int
-	pending  map[string][]chan result
+	cache    map[string]V
+	pending  map[string][]chan result[V]
 	maxBatch int
 	delay    time.Duration
 }
 
-type result struct {
-	val int
+type result[V any] struct {
+	val V
 	err error
 }
 
-func NewCommentsCountLoader(st store.Store, delay time.Duration, maxBatch int) *CommentsCountLoader {
-	return &CommentsCountLoader{
-		st:       st,
-		cache:    make(map[string]int),
-		pending:  make(map[string][]chan result),
+func newLoader[V any](fetch func(ctx context.Context, keys []string) (map[string]V, error), delay time.Duration, maxBatch int) *Loader[V] {
+	return &Loader[V]{
+		fetch:    fetch,
+		cache:    make(map[string]V),
+		pending:  make(map[string][]chan result[V]),
 		delay:    delay,
 		maxBatch: maxBatch,
 	}
 }
 
-func (l *CommentsCountLoader) Load(ctx context.Context, postID string) (int, error) {
+type PostTagsLoader = Loader[[]string]
+
+func NewPostTagsLoader(st store.Store, delay time.Duration, maxBatch int) *PostTagsLoader {
+	return newLoader(st.BatchPostTags, delay, maxBatch)
+}
+
+type PostLoader = Loader[*model.Post]
+
+func NewPostLoader(st store.Store, delay time.Duration, maxBatch int) *PostLoader {
+	return newLoader(st.GetPosts, delay, maxBatch)
+}
+
+type CommentLoader = Loader[*model.Comment]
+
+func NewCommentLoader(st store.Store, delay time.Duration, maxBatch int) *CommentLoader {
+	return newLoader(st.GetComments, delay, maxBatch)
+}
+
+type UserLoader = Loader[*model.User]
+
+func NewUserLoader(st store.Store, delay time.Duration, maxBatch int) *UserLoader {
+	return newLoader(st.GetUsers, delay, maxBatch)
+}
+
+// ReactionsLoader грузит сводку реакций; ключ — reactionsKey(viewer, targetID).
+type ReactionsLoader = Loader[[]*model.ReactionCount]
+
+func NewReactionsLoader(st store.Store, delay time.Duration, maxBatch int) *ReactionsLoader {
+	return newLoader(func(ctx context.Context, keys []string) (map[string][]*model.ReactionCount, error) {
+		// зритель обычно один на весь запрос, так что группы почти всегда одна
+		byViewer := map[string][]string{}
+		for _, k := range keys {
+			viewer, target := splitReactionsKey(k)
+			byViewer[viewer] = append(byViewer[viewer], target)
+		}
+
+		out := make(map[string][]*model.ReactionCount, len(keys))
+		for viewer, targets := range byViewer {
+			m, err := st.BatchReactions(ctx, targets, viewer)
+			if err != nil {
+				return nil, err
+			}
+			for target, counts := range m {
+				out[reactionsKey(viewer, target)] = counts
+			}
+		}
+		return out, nil
+	}, delay, maxBatch)
+}
+
+func reactionsKey(viewer, targetID string) string {
+	return viewer + "\x00" + targetID
+}
+
+func splitReactionsKey(key string) (viewer, targetID string) {
+	viewer, targetID, _ = strings.Cut(key, "\x00")
+	return viewer, targetID
+}
+
+func (l *Loader[V]) Load(ctx context.Context, key string) (V, error) {
 	l.mu.Lock()
-	if v, ok := l.cache[postID]; ok {
+	if v, ok := l.cache[key]; ok {
 		l.mu.Unlock()
 		return v, nil
 	}
 
-	ch := make(chan result, 1)
-	l.pending[postID] = append(l.pending[postID], ch)
+	ch := make(chan result[V], 1)
+	l.pending[key] = append(l.pending[key], ch)
 
-	if len(l.pending) == 1 {
-		delay := l.delay
-		if delay <= 0 {
-			delay = time.Millisecond
-		}
-		go l.flushAfter(delay)
+	if len(l.pending) == 1 && len(l.pending[key]) == 1 {
+		l.scheduleFlush()
 	}
 	l.mu.Unlock()
 
@@ -86,16 +153,26 @@ func (l *CommentsCountLoader) Load(ctx context.Context, postID string) (int, err
 	case res := <-ch:
 		return res.val, res.err
 	case <-ctx.Done():
-		return 0, ctx.Err()
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// scheduleFlush вызывается под l.mu.
+func (l *Loader[V]) scheduleFlush() {
+	delay := l.delay
+	if delay <= 0 {
+		delay = time.Millisecond
 	}
+	go l.flushAfter(delay)
 }
 
-func (l *CommentsCountLoader) flushAfter(delay time.Duration) {
+func (l *Loader[V]) flushAfter(delay time.Duration) {
 	time.Sleep(delay)
 	l.mu.Lock()
 
 	keys := make([]string, 0, len(l.pending))
-	waiters := make(map[string][]chan result, len(l.pending))
+	waiters := make(map[string][]chan result[V], len(l.pending))
 
 	for k, arr := range l.pending {
 		keys = append(keys, k)
@@ -105,18 +182,26 @@ func (l *CommentsCountLoader) flushAfter(delay time.Duration) {
 		}
 	}
 
+	if len(keys) == 0 {
+		l.mu.Unlock()
+		return
+	}
 	for _, k := range keys {
 		delete(l.pending, k)
 	}
+	// не влезшие в батч ключи уйдут следующим заходом
+	if len(l.pending) > 0 {
+		l.scheduleFlush()
+	}
 	l.mu.Unlock()
 
-	m, err := l.st.BatchCommentsCount(context.Background(), keys)
+	m, err := l.fetch(context.Background(), keys)
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	for _, k := range keys {
-		val := 0
+		var val V
 		if err == nil {
 			if v, ok := m[k]; ok {
 				val = v
@@ -124,7 +209,7 @@ func (l *CommentsCountLoader) flushAfter(delay time.Duration) {
 			l.cache[k] = val
 		}
 		for _, ch := range waiters[k] {
-			ch <- result{val: val, err: err}
+			ch <- result[V]{val: val, err: err}
 			close(ch)
 		}
 	}
@@ -2,25 +2,406 @@ package graph
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
 )
 
-const maxCommentLen = 2000
+const (
+	maxCommentLen   = 2000
+	defaultPageSize = 20
+	maxPageSize     = 100
 
-func (r *Resolver) CommentsCount(ctx context.Context, post *model.Post) (int, error) {
-	if post.CommentsCount != 0 {
-		return post.CommentsCount, nil
+	defaultTreeDepth = 5
+	defaultTreeNodes = 200
+	maxTreeNodes     = 1000
+
+	maxTagsPerPost = 10
+	maxTagLen      = 32
+
+	maxAPITokenNameLen = 100
+)
+
+// pageLimit приводит аргумент first к допустимому размеру страницы.
+func pageLimit(first *int) int {
+	if first == nil || *first <= 0 {
+		return defaultPageSize
+	}
+	if *first > maxPageSize {
+		return maxPageSize
+	}
+	return *first
+}
+
+// lastEdges оставляет на странице последние n комментариев, когда заданы и first, и last; отброшенное начало
+// страницы означает, что перед ней есть комментарии.
+func lastEdges(page *model.CommentPage, n int) {
+	if len(page.Edges) <= n {
+		return
+	}
+	page.Edges = page.Edges[len(page.Edges)-n:]
+	page.PageInfo.HasPreviousPage = true
+	page.PageInfo.StartCursor = &page.Edges[0].Cursor
+}
+
+// normalizeCommentBody обрезает пробелы и проверяет длину текста комментария.
+func normalizeCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errors.New("comment body is required")
+	}
+	if len(body) > maxCommentLen {
+		return "", fmt.Errorf("comment body is too long (max %d)", maxCommentLen)
+	}
+	return body, nil
+}
+
+// normalizeTag приводит тег к нижнему регистру, обрезает пробелы по краям и заменяет пробелы внутри на дефис.
+func normalizeTag(tag string) (string, error) {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if tag == "" {
+		return "", errors.New("tag is required")
+	}
+	if utf8.RuneCountInString(tag) > maxTagLen {
+		return "", fmt.Errorf("tag is too long (max %d)", maxTagLen)
+	}
+	return tag, nil
+}
+
+// normalizeTags нормализует теги поста и убирает повторы, сохраняя порядок.
+func normalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := normalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	if len(out) > maxTagsPerPost {
+		return nil, fmt.Errorf("invalid tags: at most %d per post", maxTagsPerPost)
+	}
+	return out, nil
+}
+
+// postTags возвращает теги поста, по возможности через батч-лоадер запроса.
+func (r *Resolver) postTags(ctx context.Context, postID string) ([]string, error) {
+	var tags []string
+	loaders := GetLoaders(ctx)
+	if loaders == nil || loaders.PostTags == nil {
+		m, err := r.Store.BatchPostTags(ctx, []string{postID})
+		if err != nil {
+			return nil, err
+		}
+		tags = m[postID]
+	} else {
+		var err error
+		if tags, err = loaders.PostTags.Load(ctx, postID); err != nil {
+			return nil, err
+		}
+	}
+
+	if tags == nil {
+		tags = []string{}
+	}
+	return tags, nil
+}
+
+// replyParent поднимается от parent по ветке, пока ответ не укладывается в MaxCommentDepth.
+func (r *Resolver) replyParent(ctx context.Context, st store.Store, parent *model.Comment) (*model.Comment, error) {
+	for r.MaxCommentDepth > 0 && parent.Depth >= r.MaxCommentDepth && parent.ParentID != nil {
+		var err error
+		if parent, err = st.GetComment(ctx, *parent.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	return parent, nil
+}
+
+// threadLocked сообщает, закрыта ли модератором ветка, в которую входит c: сам он или один из его предков.
+func threadLocked(ctx context.Context, st store.Store, c *model.Comment) (bool, error) {
+	for !c.Locked && c.ParentID != nil {
+		var err error
+		if c, err = st.GetComment(ctx, *c.ParentID); err != nil {
+			return false, err
+		}
+	}
+	return c.Locked, nil
+}
+
+// postVisible сообщает, виден ли пост зрителю: черновики и отложенные посты видит только автор.
+func postVisible(post *model.Post, viewer *string) bool {
+	return post.Status == model.PostStatusPublished || (viewer != nil && *viewer == post.Author)
+}
+
+// lockComment читает комментарий id и его пост для правки в транзакции. Внутри WithTx чтение блокирует строку,
+// поэтому порядок везде один — сначала пост, потом комментарий, как в addComment: иначе параллельные ответ
+// и удаление в PostgreSQL ждут друг друга, и одна из транзакций падает с deadlock.
+func lockComment(ctx context.Context, tx store.Store, id string) (*model.Comment, *model.Post, error) {
+	// GetComments не блокирует: он нужен только чтобы узнать пост
+	found, err := tx.GetComments(ctx, []string{id})
+	if err != nil {
+		return nil, nil, err
+	}
+	c, ok := found[id]
+	if !ok {
+		return nil, nil, store.ErrNotFound
+	}
+	post, err := tx.GetPost(ctx, c.PostID)
+	if err != nil {
+		return nil, nil, err
+	}
+	comment, err := tx.GetComment(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return comment, post, nil
+}
+
+// reactionTarget определяет, чей это id — комментария или поста, и возвращает пост, к которому он относится.
+func reactionTarget(ctx context.Context, st store.Store, targetID string) (postID string, commentID *string, err error) {
+	comment, _, err := lockComment(ctx, st, targetID)
+	if err == nil {
+		if comment.Deleted {
+			return "", nil, errors.New("forbidden: comment is deleted")
+		}
+		return comment.PostID, &comment.ID, nil
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return "", nil, err
+	}
+
+	post, err := st.GetPost(ctx, targetID)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return "", nil, errors.New("reaction target not found")
+		}
+		return "", nil, err
+	}
+	if post.Status != model.PostStatusPublished {
+		return "", nil, errors.New("reaction target not found")
+	}
+	return post.ID, nil, nil
+}
+
+// reactionCounts возвращает сводку реакций цели, по возможности через батч-лоадер запроса.
+func (r *Resolver) reactionCounts(ctx context.Context, targetID string, viewer *string) ([]*model.ReactionCount, error) {
+	viewer = r.viewer(ctx, viewer)
+	v := ""
+	if viewer != nil {
+		v = *viewer
 	}
 
 	loaders := GetLoaders(ctx)
-	if loaders == nil || loaders.CommentsCount == nil {
-		m, err := r.Store.BatchCommentsCount(ctx, []string{post.ID})
+	if loaders == nil || loaders.Reactions == nil {
+		m, err := r.Store.BatchReactions(ctx, []string{targetID}, v)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		return m[post.ID], nil
+		return m[targetID], nil
 	}
 
-	return loaders.CommentsCount.Load(ctx, post.ID)
+	return loaders.Reactions.Load(ctx, reactionsKey(v, targetID))
+}
+
+// maxIdempotencyKeyLen — наибольшая длина ключа идемпотентности в байтах.
+const maxIdempotencyKeyLen = 128
+
+// withIdempotency выполняет fn в транзакции один раз на ключ: повтор с тем же key от user в пределах IdempotencyTTL
+// не вызывает fn, а заполняет out сохранённым результатом первого вызова и возвращает replayed = true.
+// out — указатель на результат мутации или nil, если сохранять нечего. Без key это просто WithTx.
+func (r *Resolver) withIdempotency(ctx context.Context, op string, user string, key *string, out any, fn func(tx store.Store) error) (replayed bool, err error) {
+	if key == nil || *key == "" {
+		return false, r.Store.WithTx(ctx, fn)
+	}
+	if len(*key) > maxIdempotencyKeyLen {
+		return false, errors.New("idempotencyKey is too long")
+	}
+
+	now := time.Now().UTC()
+	var since time.Time
+	if r.IdempotencyTTL > 0 {
+		since = now.Add(-r.IdempotencyTTL)
+	}
+
+	err = r.Store.WithTx(ctx, func(tx store.Store) error {
+		prev, err := tx.ClaimIdempotencyKey(ctx, store.IdempotencyKey{User: user, Key: *key, Op: op, CreatedAt: now}, since)
+		if err != nil {
+			return err
+		}
+		if prev != nil {
+			if prev.Op != op {
+				return fmt.Errorf("invalid idempotencyKey: already used for %s", prev.Op)
+			}
+			replayed = true
+			if out == nil {
+				return nil
+			}
+			return json.Unmarshal(prev.Result, out)
+		}
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+		result, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		return tx.SetIdempotencyResult(ctx, user, *key, result)
+	})
+	return replayed, err
+}
+
+// actor возвращает имя пользователя, от которого выполняется мутация. Это пользователь из контекста запроса;
+// устаревший аргумент author/user должен с ним совпадать, а без аутентификации учитывается только с AllowUserArgs.
+// Вошедшему личным токеном нужно право scope.
+func (r *Resolver) actor(ctx context.Context, arg *string, scope string) (string, error) {
+	if p := auth.From(ctx); p != nil {
+		if arg != nil && *arg != "" && *arg != p.Name {
+			return "", errors.New("forbidden: user does not match authenticated user")
+		}
+		if err := requireScope(ctx, scope); err != nil {
+			return "", err
+		}
+		return p.Name, nil
+	}
+	if r.AllowUserArgs && arg != nil && *arg != "" {
+		return *arg, nil
+	}
+	return "", errors.New("auth is required")
+}
+
+// viewer возвращает зрителя запроса: аутентифицированного пользователя, а для анонимного запроса — аргумент viewer,
+// если он разрешён AllowUserArgs.
+func (r *Resolver) viewer(ctx context.Context, viewer *string) *string {
+	if p := auth.From(ctx); p != nil {
+		return &p.Name
+	}
+	if r.AllowUserArgs {
+		return viewer
+	}
+	return nil
+}
+
+// roleRank упорядочивает роли: старшей роли доступно всё, что и младшей.
+var roleRank = map[model.Role]int{
+	model.RoleUser:      0,
+	model.RoleModerator: 1,
+	model.RoleAdmin:     2,
+}
+
+// HasRole реализует директиву @hasRole: пускает к полю только пользователя с ролью не ниже role.
+// Роль берётся из хранилища, поэтому снятая роль действует сразу; личному токену нужно ещё право moderate.
+func (r *Resolver) HasRole(ctx context.Context, obj any, next graphql.Resolver, role model.Role) (any, error) {
+	if err := requireRole(ctx, r.Store, role); err != nil {
+		return nil, err
+	}
+	return next(ctx)
+}
+
+// requireRole — проверка @hasRole для мутаций, которые решают о доступе сами; st — текущая транзакция.
+func requireRole(ctx context.Context, st store.Store, role model.Role) error {
+	p := auth.From(ctx)
+	if p == nil {
+		return errors.New("auth is required")
+	}
+	if err := requireScope(ctx, auth.ScopeModerate); err != nil {
+		return err
+	}
+
+	have, err := userRole(ctx, st, p.Name)
+	if err != nil {
+		return err
+	}
+	if roleRank[have] < roleRank[role] {
+		return fmt.Errorf("forbidden: %s role is required", role)
+	}
+	return nil
+}
+
+// authorize пускает владельца записи (caller среди owners) или модератора, остальным отказывает ошибкой denied.
+// Модератор должен войти сам: имя из аргумента user при AllowUserArgs модератором не считается.
+func authorize(ctx context.Context, st store.Store, caller, denied string, owners ...string) error {
+	if slices.Contains(owners, caller) {
+		return nil
+	}
+	if auth.From(ctx) == nil {
+		return errors.New(denied)
+	}
+	if err := requireScope(ctx, auth.ScopeModerate); err != nil {
+		return err
+	}
+
+	have, err := userRole(ctx, st, caller)
+	if err != nil {
+		return err
+	}
+	if roleRank[have] < roleRank[model.RoleModerator] {
+		return errors.New(denied)
+	}
+	return nil
+}
+
+// userRole возвращает роль пользователя name; пользователь, которого ещё нет в хранилище, — USER.
+func userRole(ctx context.Context, st store.Store, name string) (model.Role, error) {
+	id := store.UserID(name)
+	users, err := st.GetUsers(ctx, []string{id})
+	if err != nil {
+		return "", err
+	}
+	if u := users[id]; u != nil {
+		return u.Role, nil
+	}
+	return model.RoleUser, nil
+}
+
+// requireScope проверяет право личного токена, которым вошёл пользователь; другим способам входа разрешено всё.
+func requireScope(ctx context.Context, scope string) error {
+	if p := auth.From(ctx); p != nil && !p.Allows(scope) {
+		return errors.New("forbidden: api token has no " + scope + " scope")
+	}
+	return nil
+}
+
+// tokenOwner возвращает пользователя, который может управлять личными токенами: вошедшего не личным токеном,
+// иначе утёкший токен можно было бы продлить или расширить.
+func tokenOwner(ctx context.Context) (*auth.Principal, error) {
+	p := auth.From(ctx)
+	if p == nil {
+		return nil, errors.New("auth is required")
+	}
+	if p.TokenID != "" {
+		return nil, errors.New("forbidden: api tokens cannot manage api tokens")
+	}
+	return p, nil
+}
+
+// normalizeScopes проверяет права токена и упорядочивает их как в auth.Scopes.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("scopes are required")
+	}
+	for _, s := range scopes {
+		if !slices.Contains(auth.Scopes, s) {
+			return nil, errors.New("invalid scope: " + s)
+		}
+	}
+	out := make([]string, 0, len(scopes))
+	for _, s := range auth.Scopes {
+		if slices.Contains(scopes, s) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
 }
@@ -3,49 +3,436 @@
 package model
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
+type Node interface {
+	IsNode()
+	GetID() string
+}
+
+type APITokenCreated struct {
+	Token    string    `json:"token"`
+	APIToken *APIToken `json:"apiToken"`
+}
+
 type Comment struct {
-	ID        string    `json:"id"`
-	PostID    string    `json:"postID"`
-	ParentID  *string   `json:"parentID,omitempty"`
-	Author    string    `json:"author"`
-	Body      string    `json:"body"`
-	Depth     int       `json:"depth"`
-	CreatedAt time.Time `json:"createdAt"`
+	Body      string     `json:"body"`
+	Depth     int        `json:"depth"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+	Deleted   bool       `json:"deleted"`
+	Locked    bool       `json:"locked"`
+	Version   int        `json:"version"`
+	Author    string     `json:"author"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	ID        string     `json:"id"`
+	ParentID  *string    `json:"parentID,omitempty"`
+	PostID    string     `json:"postID"`
+	ReplyToID *string    `json:"replyToID,omitempty"`
 }
 
+func (Comment) IsNode()            {}
+func (this Comment) GetID() string { return this.ID }
+
 type CommentEdge struct {
 	Cursor string   `json:"cursor"`
 	Node   *Comment `json:"node"`
 }
 
 type CommentPage struct {
-	Edges    []*CommentEdge `json:"edges"`
-	PageInfo *PageInfo      `json:"pageInfo"`
+	Edges      []*CommentEdge `json:"edges"`
+	PageInfo   *PageInfo      `json:"pageInfo"`
+	TotalCount int            `json:"totalCount"`
+}
+
+type CommentRevision struct {
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type CommentTreeNode struct {
+	Comment   *Comment           `json:"comment"`
+	Children  []*CommentTreeNode `json:"children"`
+	Truncated bool               `json:"truncated"`
 }
 
 type Mutation struct {
 }
 
 type PageInfo struct {
-	EndCursor   *string `json:"endCursor,omitempty"`
-	HasNextPage bool    `json:"hasNextPage"`
+	StartCursor     *string `json:"startCursor,omitempty"`
+	EndCursor       *string `json:"endCursor,omitempty"`
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
 }
 
 type Post struct {
-	ID             string    `json:"id"`
-	Title          string    `json:"title"`
-	Body           string    `json:"body"`
-	Author         string    `json:"author"`
-	CommentsClosed bool      `json:"commentsClosed"`
-	CreatedAt      time.Time `json:"createdAt"`
-	CommentsCount  int       `json:"commentsCount"`
+	Title          string     `json:"title"`
+	Body           string     `json:"body"`
+	CommentsClosed bool       `json:"commentsClosed"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	CommentsCount  int        `json:"commentsCount"`
+	Status         PostStatus `json:"status"`
+	PublishAt      *time.Time `json:"publishAt,omitempty"`
+	Version        int        `json:"version"`
+	Author         string     `json:"author"`
+	ID             string     `json:"id"`
+}
+
+func (Post) IsNode()            {}
+func (this Post) GetID() string { return this.ID }
+
+type PostEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Post  `json:"node"`
+}
+
+type PostPage struct {
+	Edges    []*PostEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
 }
 
 type Query struct {
 }
 
+type ReactionCount struct {
+	Kind             ReactionKind `json:"kind"`
+	Count            int          `json:"count"`
+	ViewerHasReacted bool         `json:"viewerHasReacted"`
+}
+
+type SearchEdge struct {
+	Cursor string     `json:"cursor"`
+	Node   *SearchHit `json:"node"`
+}
+
+type SearchHit struct {
+	Kind    SearchHitKind `json:"kind"`
+	Score   float64       `json:"score"`
+	Snippet string        `json:"snippet"`
+	Post    *Post         `json:"post"`
+	Comment *Comment      `json:"comment,omitempty"`
+}
+
+type SearchPage struct {
+	Edges    []*SearchEdge `json:"edges"`
+	PageInfo *PageInfo     `json:"pageInfo"`
+}
+
 type Subscription struct {
 }
+
+type Tag struct {
+	Name       string `json:"name"`
+	PostsCount int    `json:"postsCount"`
+}
+
+type User struct {
+	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+	ID        string    `json:"id"`
+}
+
+func (User) IsNode()            {}
+func (this User) GetID() string { return this.ID }
+
+type Viewer struct {
+	User *User `json:"user"`
+}
+
+type CommentOrder string
+
+const (
+	CommentOrderOldest   CommentOrder = "OLDEST"
+	CommentOrderNewest   CommentOrder = "NEWEST"
+	CommentOrderTop      CommentOrder = "TOP"
+	CommentOrderThreaded CommentOrder = "THREADED"
+)
+
+var AllCommentOrder = []CommentOrder{
+	CommentOrderOldest,
+	CommentOrderNewest,
+	CommentOrderTop,
+	CommentOrderThreaded,
+}
+
+func (e CommentOrder) IsValid() bool {
+	switch e {
+	case CommentOrderOldest, CommentOrderNewest, CommentOrderTop, CommentOrderThreaded:
+		return true
+	}
+	return false
+}
+
+func (e CommentOrder) String() string {
+	return string(e)
+}
+
+func (e *CommentOrder) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CommentOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CommentOrder", str)
+	}
+	return nil
+}
+
+func (e CommentOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *CommentOrder) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e CommentOrder) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type PostStatus string
+
+const (
+	PostStatusDraft     PostStatus = "DRAFT"
+	PostStatusScheduled PostStatus = "SCHEDULED"
+	PostStatusPublished PostStatus = "PUBLISHED"
+)
+
+var AllPostStatus = []PostStatus{
+	PostStatusDraft,
+	PostStatusScheduled,
+	PostStatusPublished,
+}
+
+func (e PostStatus) IsValid() bool {
+	switch e {
+	case PostStatusDraft, PostStatusScheduled, PostStatusPublished:
+		return true
+	}
+	return false
+}
+
+func (e PostStatus) String() string {
+	return string(e)
+}
+
+func (e *PostStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = PostStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid PostStatus", str)
+	}
+	return nil
+}
+
+func (e PostStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *PostStatus) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e PostStatus) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type ReactionKind string
+
+const (
+	ReactionKindThumbsUp   ReactionKind = "THUMBS_UP"
+	ReactionKindThumbsDown ReactionKind = "THUMBS_DOWN"
+	ReactionKindHeart      ReactionKind = "HEART"
+	ReactionKindLaugh      ReactionKind = "LAUGH"
+	ReactionKindSurprised  ReactionKind = "SURPRISED"
+	ReactionKindSad        ReactionKind = "SAD"
+)
+
+var AllReactionKind = []ReactionKind{
+	ReactionKindThumbsUp,
+	ReactionKindThumbsDown,
+	ReactionKindHeart,
+	ReactionKindLaugh,
+	ReactionKindSurprised,
+	ReactionKindSad,
+}
+
+func (e ReactionKind) IsValid() bool {
+	switch e {
+	case ReactionKindThumbsUp, ReactionKindThumbsDown, ReactionKindHeart, ReactionKindLaugh, ReactionKindSurprised, ReactionKindSad:
+		return true
+	}
+	return false
+}
+
+func (e ReactionKind) String() string {
+	return string(e)
+}
+
+func (e *ReactionKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ReactionKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ReactionKind", str)
+	}
+	return nil
+}
+
+func (e ReactionKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *ReactionKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e ReactionKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type Role string
+
+const (
+	RoleUser      Role = "USER"
+	RoleModerator Role = "MODERATOR"
+	RoleAdmin     Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleModerator,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Role) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Role) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type SearchHitKind string
+
+const (
+	SearchHitKindComment SearchHitKind = "COMMENT"
+	SearchHitKindPost    SearchHitKind = "POST"
+)
+
+var AllSearchHitKind = []SearchHitKind{
+	SearchHitKindComment,
+	SearchHitKindPost,
+}
+
+func (e SearchHitKind) IsValid() bool {
+	switch e {
+	case SearchHitKindComment, SearchHitKindPost:
+		return true
+	}
+	return false
+}
+
+func (e SearchHitKind) String() string {
+	return string(e)
+}
+
+func (e *SearchHitKind) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SearchHitKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SearchHitKind", str)
+	}
+	return nil
+}
+
+func (e SearchHitKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *SearchHitKind) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e SearchHitKind) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,198 @@
+package graph
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
+)
+
+// Типы глобальных id: имя GraphQL-типа перед двоеточием.
+const (
+	nodePost    = "Post"
+	nodeComment = "Comment"
+	nodeUser    = "User"
+)
+
+// maxNodeIDs ограничивает число id в одном запросе nodes.
+const maxNodeIDs = 100
+
+// globalID кодирует внутренний id объекта в глобальный id Relay: base64("<тип>:<id>").
+func globalID(typ, id string) string {
+	return base64.StdEncoding.EncodeToString([]byte(typ + ":" + id))
+}
+
+func globalIDPtr(typ string, id *string) *string {
+	if id == nil {
+		return nil
+	}
+	g := globalID(typ, *id)
+	return &g
+}
+
+// parseGlobalID раскладывает глобальный id на тип и внутренний id.
+func parseGlobalID(id string) (typ, local string, ok bool) {
+	raw, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return "", "", false
+	}
+	typ, local, ok = strings.Cut(string(raw), ":")
+	if !ok || (typ != nodePost && typ != nodeComment && typ != nodeUser) || local == "" {
+		return "", "", false
+	}
+	return typ, local, true
+}
+
+// localID переводит id из аргумента во внутренний. Кроме глобального id принимается и прежний внутренний,
+// чтобы старые клиенты продолжали работать; глобальный id другого типа — ошибка.
+func localID(typ, id string) (string, error) {
+	t, local, ok := parseGlobalID(id)
+	if !ok {
+		return id, nil
+	}
+	if t != typ {
+		return "", errors.New("invalid id: expected " + typ + " id")
+	}
+	return local, nil
+}
+
+func localIDPtr(typ string, id *string) (*string, error) {
+	if id == nil {
+		return nil, nil
+	}
+	local, err := localID(typ, *id)
+	if err != nil {
+		return nil, err
+	}
+	return &local, nil
+}
+
+// anyLocalID переводит id цели, которая может быть и постом, и комментарием.
+func anyLocalID(id string) string {
+	if _, local, ok := parseGlobalID(id); ok {
+		return local
+	}
+	return id
+}
+
+// loadNodes загружает объекты по глобальным id через батч-лоадеры запроса; ненайденные и невидимые зрителю — nil.
+func (r *Resolver) loadNodes(ctx context.Context, ids []string, viewer *string) ([]model.Node, error) {
+	viewer = r.viewer(ctx, viewer)
+	var postIDs, commentIDs, userIDs []string
+	for _, id := range ids {
+		typ, local, ok := parseGlobalID(id)
+		if !ok {
+			return nil, errors.New("invalid id: " + id)
+		}
+		switch typ {
+		case nodePost:
+			postIDs = append(postIDs, local)
+		case nodeComment:
+			commentIDs = append(commentIDs, local)
+		case nodeUser:
+			userIDs = append(userIDs, local)
+		}
+	}
+
+	posts, err := r.loadPosts(ctx, postIDs)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := r.loadComments(ctx, commentIDs)
+	if err != nil {
+		return nil, err
+	}
+	users, err := r.loadUsers(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]model.Node, len(ids))
+	for i, id := range ids {
+		typ, local, _ := parseGlobalID(id)
+		switch typ {
+		case nodePost:
+			if p := posts[local]; p != nil && postVisible(p, viewer) {
+				out[i] = p
+			}
+		case nodeComment:
+			if c := comments[local]; c != nil {
+				out[i] = c
+			}
+		case nodeUser:
+			if u := users[local]; u != nil {
+				out[i] = u
+			}
+		}
+	}
+	return out, nil
+}
+
+func (r *Resolver) loadPosts(ctx context.Context, ids []string) (map[string]*model.Post, error) {
+	loaders := GetLoaders(ctx)
+	if loaders == nil || loaders.Posts == nil {
+		return r.Store.GetPosts(ctx, ids)
+	}
+	return loadAll(ctx, loaders.Posts, ids)
+}
+
+func (r *Resolver) loadComments(ctx context.Context, ids []string) (map[string]*model.Comment, error) {
+	loaders := GetLoaders(ctx)
+	if loaders == nil || loaders.Comments == nil {
+		return r.Store.GetComments(ctx, ids)
+	}
+	return loadAll(ctx, loaders.Comments, ids)
+}
+
+func (r *Resolver) loadUsers(ctx context.Context, ids []string) (map[string]*model.User, error) {
+	loaders := GetLoaders(ctx)
+	if loaders == nil || loaders.Users == nil {
+		return r.Store.GetUsers(ctx, ids)
+	}
+	return loadAll(ctx, loaders.Users, ids)
+}
+
+// loadUser возвращает пользователя с именем name.
+func (r *Resolver) loadUser(ctx context.Context, name string) (*model.User, error) {
+	id := store.UserID(name)
+	users, err := r.loadUsers(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if u := users[id]; u != nil {
+		return u, nil
+	}
+	return nil, errors.New("user not found: " + name)
+}
+
+// loadAll запрашивает ключи у лоадера; все они попадают в один батч с остальными запросами этого окна.
+func loadAll[V comparable](ctx context.Context, l *Loader[V], keys []string) (map[string]V, error) {
+	type res struct {
+		key string
+		val V
+		err error
+	}
+	ch := make(chan res, len(keys))
+	for _, k := range keys {
+		go func() {
+			v, err := l.Load(ctx, k)
+			ch <- res{key: k, val: v, err: err}
+		}()
+	}
+
+	var zero V
+	out := make(map[string]V, len(keys))
+	for range keys {
+		r := <-ch
+		if r.err != nil {
+			return nil, r.err
+		}
+		if r.val != zero {
+			out[r.key] = r.val
+		}
+	}
+	return out, nil
+}
@@ -1,6 +1,10 @@
 package graph
 
 import (
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/generated"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/pubsub"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
 	"github.com/rs/zerolog"
@@ -10,4 +14,23 @@ type Resolver struct {
 	Store  store.Store
 	Bus    pubsub.Bus
 	Logger zerolog.Logger
+
+	// CommentEditWindow — сколько времени после создания автор может править комментарий; 0 — без ограничения.
+	CommentEditWindow time.Duration
+	// MaxCommentDepth — наибольшая глубина ответа; ответы глубже прикрепляются выше по ветке. 0 — без ограничения.
+	MaxCommentDepth int
+	// IdempotencyTTL — сколько хранится результат мутации по ключу идемпотентности; 0 — без срока.
+	IdempotencyTTL time.Duration
+	// AllowUserArgs разрешает анонимным запросам действовать от имени устаревших аргументов author и user.
+	// Нужен на время перехода клиентов на аутентификацию: с ним кто угодно может писать от чужого имени.
+	AllowUserArgs bool
+}
+
+// NewExecutableSchema собирает схему с резолверами r и директивами: без реализации директивы gqlgen
+// отказывал бы в доступе к помеченным ею полям.
+func NewExecutableSchema(r *Resolver) graphql.ExecutableSchema {
+	return generated.NewExecutableSchema(generated.Config{
+		Resolvers:  r,
+		Directives: generated.DirectiveRoot{HasRole: r.HasRole},
+	})
 }
@@ -2,10 +2,20 @@ package graph_test
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/99designs/gqlgen/client"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/pubsub"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
 )
@@ -14,14 +24,20 @@ func newResolverForTests() *graph.Resolver {
 	return &graph.Resolver{
 		Store: store.NewMemStore(),
 		Bus:   pubsub.NewMemoryBus(),
+		// тесты действуют от имени пользователя из устаревших аргументов
+		AllowUserArgs: true,
 	}
 }
 
+func strPtr(s string) *string {
+	return &s
+}
+
 func TestCreatePostAndAddComment(t *testing.T) {
 	r := newResolverForTests()
 	ctx := context.Background()
 
-	p, err := r.Mutation().CreatePost(ctx, "T", "B", "author")
+	p, err := r.Mutation().CreatePost(ctx, "T", "B", strPtr("author"), nil, nil, nil)
 	if err != nil {
 		t.Fatalf("create post: %v", err)
 	}
@@ -30,7 +46,7 @@ func TestCreatePostAndAddComment(t *testing.T) {
 	}
 
 	// Добавим валидный комментарий
-	c, err := r.Mutation().AddComment(ctx, p.ID, nil, "hello", "bob")
+	c, err := r.Mutation().AddComment(ctx, p.ID, nil, "hello", strPtr("bob"), nil)
 	if err != nil {
 		t.Fatalf("add comment: %v", err)
 	}
@@ -39,7 +55,7 @@ func TestCreatePostAndAddComment(t *testing.T) {
 	}
 
 	// Проверим, что Comments возвращает его
-	conn, err := r.Query().Comments(ctx, p.ID, nil, nil, nil)
+	conn, err := r.Query().Comments(ctx, p.ID, nil, nil, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatalf("comments: %v", err)
 	}
@@ -52,14 +68,14 @@ func TestAddComment_Validation(t *testing.T) {
 	r := newResolverForTests()
 	ctx := context.Background()
 
-	p, _ := r.Mutation().CreatePost(ctx, "t", "b", "u")
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
 	// пустой
-	if _, err := r.Mutation().AddComment(ctx, p.ID, nil, "   ", "bob"); err == nil {
+	if _, err := r.Mutation().AddComment(ctx, p.ID, nil, "   ", strPtr("bob"), nil); err == nil {
 		t.Fatal("expected empty body error")
 	}
 	// слишком длинный
 	long := strings.Repeat("x", 2001)
-	if _, err := r.Mutation().AddComment(ctx, p.ID, nil, long, "bob"); err == nil {
+	if _, err := r.Mutation().AddComment(ctx, p.ID, nil, long, strPtr("bob"), nil); err == nil {
 		t.Fatal("expected too long error")
 	}
 }
@@ -68,9 +84,9 @@ func TestAddComment_WithParentAndDepth(t *testing.T) {
 	r := newResolverForTests()
 	ctx := context.Background()
 
-	p, _ := r.Mutation().CreatePost(ctx, "t", "b", "u")
-	root, _ := r.Mutation().AddComment(ctx, p.ID, nil, "root", "a")
-	child, err := r.Mutation().AddComment(ctx, p.ID, &root.ID, "child", "b")
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	root, _ := r.Mutation().AddComment(ctx, p.ID, nil, "root", strPtr("a"), nil)
+	child, err := r.Mutation().AddComment(ctx, p.ID, &root.ID, "child", strPtr("b"), nil)
 	if err != nil {
 		t.Fatalf("add child: %v", err)
 	}
@@ -83,12 +99,12 @@ func TestToggleCommentsClosed(t *testing.T) {
 	r := newResolverForTests()
 	ctx := context.Background()
 
-	p, _ := r.Mutation().CreatePost(ctx, "t", "b", "u")
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
 	if p.CommentsClosed {
 		t.Fatalf("expected comments open by default")
 	}
 	// toggle to closed
-	np, err := r.Mutation().ToggleCommentsClosed(ctx, p.ID, true, "u")
+	np, err := r.Mutation().ToggleCommentsClosed(ctx, p.ID, true, strPtr("u"), nil, nil)
 	if err != nil {
 		t.Fatalf("toggle: %v", err)
 	}
@@ -96,7 +112,7 @@ func TestToggleCommentsClosed(t *testing.T) {
 		t.Fatalf("expected comments closed")
 	}
 	// toggle back to open
-	np, err = r.Mutation().ToggleCommentsClosed(ctx, p.ID, false, "u")
+	np, err = r.Mutation().ToggleCommentsClosed(ctx, p.ID, false, strPtr("u"), nil, nil)
 	if err != nil {
 		t.Fatalf("toggle back: %v", err)
 	}
@@ -104,3 +120,814 @@ func TestToggleCommentsClosed(t *testing.T) {
 		t.Fatalf("expected comments open")
 	}
 }
+
+func TestEditComment_KeepsRevisions(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	c, _ := r.Mutation().AddComment(ctx, p.ID, nil, "first", strPtr("bob"), nil)
+
+	if _, err := r.Mutation().EditComment(ctx, c.ID, "hacked", strPtr("eve"), nil, nil); err == nil {
+		t.Fatal("expected forbidden for non-author")
+	}
+	if _, err := r.Mutation().EditComment(ctx, c.ID, "   ", strPtr("bob"), nil, nil); err == nil {
+		t.Fatal("expected empty body error")
+	}
+
+	edited, err := r.Mutation().EditComment(ctx, c.ID, "  second  ", strPtr("bob"), nil, nil)
+	if err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if edited.Body != "second" || edited.EditedAt == nil {
+		t.Fatalf("unexpected edited comment: %+v", edited)
+	}
+
+	revs, err := r.Comment().Revisions(ctx, edited)
+	if err != nil {
+		t.Fatalf("revisions: %v", err)
+	}
+	if len(revs) != 1 || revs[0].Body != "first" {
+		t.Fatalf("unexpected revisions: %+v", revs)
+	}
+}
+
+func TestEditComment_WindowExpired(t *testing.T) {
+	r := newResolverForTests()
+	r.CommentEditWindow = time.Nanosecond
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	c, _ := r.Mutation().AddComment(ctx, p.ID, nil, "first", strPtr("bob"), nil)
+	time.Sleep(time.Millisecond)
+
+	if _, err := r.Mutation().EditComment(ctx, c.ID, "second", strPtr("bob"), nil, nil); err == nil {
+		t.Fatal("expected edit window error")
+	}
+}
+
+func TestDeleteComment_KeepsThread(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("owner"), nil, nil, nil)
+	root, _ := r.Mutation().AddComment(ctx, p.ID, nil, "root", strPtr("alice"), nil)
+	child, _ := r.Mutation().AddComment(ctx, p.ID, &root.ID, "child", strPtr("bob"), nil)
+
+	if _, err := r.Mutation().DeleteComment(ctx, root.ID, strPtr("bob"), nil, nil); err == nil {
+		t.Fatal("expected forbidden for stranger")
+	}
+
+	// автор поста может удалить чужой комментарий
+	deleted, err := r.Mutation().DeleteComment(ctx, root.ID, strPtr("owner"), nil, nil)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if !deleted.Deleted || deleted.Body != "" || deleted.Author != "" {
+		t.Fatalf("expected tombstone, got %+v", deleted)
+	}
+
+	conn, err := r.Query().Comments(ctx, p.ID, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("comments: %v", err)
+	}
+	if len(conn.Edges) != 2 {
+		t.Fatalf("expected tombstone and reply to stay, got %d", len(conn.Edges))
+	}
+	for _, e := range conn.Edges {
+		switch e.Node.ID {
+		case root.ID:
+			if !e.Node.Deleted || e.Node.Body != "" || e.Node.Author != "" {
+				t.Fatalf("tombstone leaks content: %+v", e.Node)
+			}
+		case child.ID:
+			if e.Node.Deleted || e.Node.Depth != 1 {
+				t.Fatalf("reply changed: %+v", e.Node)
+			}
+		}
+	}
+
+	if _, err := r.Mutation().EditComment(ctx, root.ID, "again", strPtr("alice"), nil, nil); err == nil {
+		t.Fatal("expected edit of deleted comment to fail")
+	}
+}
+
+func TestUpdatePost_OnlyAuthor(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "typo", "b", strPtr("u"), nil, nil, nil)
+	title := "fixed"
+	if _, err := r.Mutation().UpdatePost(ctx, p.ID, &title, nil, nil, strPtr("other"), nil, nil); err == nil {
+		t.Fatal("expected forbidden for non-author")
+	}
+
+	up, err := r.Mutation().UpdatePost(ctx, p.ID, &title, nil, nil, strPtr("u"), nil, nil)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if up.Title != "fixed" || up.Body != "b" {
+		t.Fatalf("unexpected post after update: %+v", up)
+	}
+}
+
+func TestUpdatePost_ExpectedVersion(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	if p.Version != 1 {
+		t.Fatalf("new post must start at version 1, got %d", p.Version)
+	}
+
+	// два редактора открыли версию 1; первый сохраняет успешно
+	v := p.Version
+	first, second := "first", "second"
+	up, err := r.Mutation().UpdatePost(ctx, p.ID, &first, nil, nil, strPtr("u"), &v, nil)
+	if err != nil || up.Version != 2 {
+		t.Fatalf("first update: %+v %v", up, err)
+	}
+
+	// второй получает конфликт, и его правка не применяется
+	_, err = r.Mutation().UpdatePost(ctx, p.ID, &second, nil, nil, strPtr("u"), &v, nil)
+	var conflict *store.ConflictError
+	if !errors.As(err, &conflict) || conflict.Actual != 2 {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	if _, err := r.Mutation().ToggleCommentsClosed(ctx, p.ID, true, strPtr("u"), &v, nil); !errors.As(err, &conflict) {
+		t.Fatalf("expected version conflict on toggle, got %v", err)
+	}
+	got, _ := r.Query().Post(ctx, p.ID, nil)
+	if got.Title != "first" || got.CommentsClosed || got.Version != 2 {
+		t.Fatalf("conflicting writes must not apply: %+v", got)
+	}
+
+	// без expectedVersion запись проходит и поднимает версию
+	closed, err := r.Mutation().ToggleCommentsClosed(ctx, p.ID, true, strPtr("u"), nil, nil)
+	if err != nil || closed.Version != 3 {
+		t.Fatalf("toggle without version: %+v %v", closed, err)
+	}
+}
+
+func TestIdempotencyKey_RetryReturnsOriginal(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	key := "retry-1"
+	p1, err := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, &key)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	p2, err := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, &key)
+	if err != nil || p2.ID != p1.ID {
+		t.Fatalf("retry must return the original post: %+v %v", p2, err)
+	}
+
+	c1, _ := r.Mutation().AddComment(ctx, p1.ID, nil, "hi", strPtr("v"), &key)
+	c2, _ := r.Mutation().AddComment(ctx, p1.ID, nil, "hi", strPtr("v"), &key)
+	if c1 == nil || c2 == nil || c1.ID != c2.ID {
+		t.Fatalf("retry must return the original comment: %+v %+v", c1, c2)
+	}
+	page, _ := r.Query().Posts(ctx, nil, nil, nil, nil, nil, nil, nil, nil)
+	if len(page.Edges) != 1 || page.Edges[0].Node.CommentsCount != 1 {
+		t.Fatalf("retries must not create duplicates: %+v", page.Edges)
+	}
+
+	// ключ чужого пользователя не пересекается, а свой для другой мутации — ошибка
+	if c, err := r.Mutation().AddComment(ctx, p1.ID, nil, "hi", strPtr("w"), &key); err != nil || c.ID == c1.ID {
+		t.Fatalf("keys must be scoped per user: %+v %v", c, err)
+	}
+	if _, err := r.Mutation().ToggleCommentsClosed(ctx, p1.ID, true, strPtr("u"), nil, &key); err == nil {
+		t.Fatal("expected error when reusing key for another mutation")
+	}
+}
+
+func TestNode_GlobalIDs(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	draft := true
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	d, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, &draft, nil)
+	pid, _ := r.Post().ID(ctx, p)
+	did, _ := r.Post().ID(ctx, d)
+	if pid == p.ID {
+		t.Fatalf("post id must be global: %s", pid)
+	}
+
+	// аргументы принимают и глобальный id, и прежний внутренний
+	c, err := r.Mutation().AddComment(ctx, pid, nil, "hi", strPtr("v"), nil)
+	if err != nil {
+		t.Fatalf("add comment by global id: %v", err)
+	}
+	cid, _ := r.Comment().ID(ctx, c)
+	if _, err := r.Mutation().AddComment(ctx, p.ID, &cid, "re", strPtr("v"), nil); err != nil {
+		t.Fatalf("add reply by global parent id: %v", err)
+	}
+	if _, err := r.Mutation().AddComment(ctx, cid, nil, "x", strPtr("v"), nil); err == nil || !strings.Contains(err.Error(), "invalid id") {
+		t.Fatalf("expected invalid id for comment id as postId, got %v", err)
+	}
+
+	n, err := r.Query().Node(ctx, cid, nil)
+	if got, ok := n.(*model.Comment); err != nil || !ok || got.ID != c.ID {
+		t.Fatalf("node(comment): %+v %v", n, err)
+	}
+
+	nodes, err := r.Query().Nodes(ctx, []string{did, pid, cid}, nil)
+	if err != nil || len(nodes) != 3 {
+		t.Fatalf("nodes: %+v %v", nodes, err)
+	}
+	if nodes[0] != nil {
+		t.Fatalf("draft must be hidden from other viewers: %+v", nodes[0])
+	}
+	if got, ok := nodes[1].(*model.Post); !ok || got.ID != p.ID {
+		t.Fatalf("nodes must keep order of ids: %+v", nodes)
+	}
+	viewer := "u"
+	if n, _ := r.Query().Node(ctx, did, &viewer); n == nil {
+		t.Fatal("author must see own draft via node")
+	}
+
+	if _, err := r.Query().Node(ctx, p.ID, nil); err == nil {
+		t.Fatal("expected error for non-global id in node")
+	}
+}
+
+func TestAuthor_ResolvesToUser(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("alice"), nil, nil, nil)
+	c, _ := r.Mutation().AddComment(ctx, p.ID, nil, "hi", strPtr("bob"), nil)
+	_, _ = r.Mutation().AddComment(ctx, p.ID, nil, "again", strPtr("alice"), nil)
+
+	pa, err := r.Post().Author(ctx, p)
+	if err != nil || pa.Name != "alice" {
+		t.Fatalf("post author: %+v %v", pa, err)
+	}
+	ca, err := r.Comment().Author(ctx, c)
+	if err != nil || ca.Name != "bob" || ca.ID == pa.ID {
+		t.Fatalf("comment author: %+v %v", ca, err)
+	}
+
+	// пользователь один на все посты и комментарии автора и доступен через node
+	uid, _ := r.User().ID(ctx, pa)
+	n, err := r.Query().Node(ctx, uid, nil)
+	if u, ok := n.(*model.User); err != nil || !ok || u.ID != pa.ID || !u.CreatedAt.Equal(p.CreatedAt) {
+		t.Fatalf("node(user): %+v %v", n, err)
+	}
+
+	deleted, _ := r.Mutation().DeleteComment(ctx, c.ID, strPtr("bob"), nil, nil)
+	if a, err := r.Comment().Author(ctx, deleted); err != nil || a != nil {
+		t.Fatalf("deleted comment must have no author: %+v %v", a, err)
+	}
+}
+
+func TestMutations_UseAuthenticatedUser(t *testing.T) {
+	r := newResolverForTests()
+	r.AllowUserArgs = false
+	anon := context.Background()
+	alice := auth.Into(anon, &auth.Principal{Name: "alice"})
+	bob := auth.Into(anon, &auth.Principal{Name: "bob"})
+
+	if _, err := r.Mutation().CreatePost(anon, "t", "b", strPtr("alice"), nil, nil, nil); err == nil || !strings.Contains(err.Error(), "auth is required") {
+		t.Fatalf("anonymous request must not act on behalf of author arg, got %v", err)
+	}
+	if _, err := r.Mutation().CreatePost(bob, "t", "b", strPtr("alice"), nil, nil, nil); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("author arg must match authenticated user, got %v", err)
+	}
+
+	draft := true
+	p, err := r.Mutation().CreatePost(alice, "t", "b", nil, nil, &draft, nil)
+	if err != nil || p.Author != "alice" {
+		t.Fatalf("create as alice: %+v %v", p, err)
+	}
+	if _, err := r.Mutation().PublishPost(bob, p.ID, nil, nil, nil); err == nil {
+		t.Fatal("expected forbidden for another user")
+	}
+
+	// черновик виден автору по аутентификации; аргумент viewer анонимного запроса не учитывается
+	if got, _ := r.Query().Post(alice, p.ID, nil); got == nil {
+		t.Fatal("author must see own draft")
+	}
+	if got, _ := r.Query().Post(anon, p.ID, strPtr("alice")); got != nil {
+		t.Fatal("viewer arg must not reveal draft without AllowUserArgs")
+	}
+}
+
+func TestAPITokens_ScopesAndRevoke(t *testing.T) {
+	r := newResolverForTests()
+	alice := auth.Into(context.Background(), &auth.Principal{Name: "alice"})
+	authenticate := func(token string) (*auth.Principal, error) {
+		req := httptest.NewRequest(http.MethodPost, "/query", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return auth.APITokens(r.Store).Authenticate(req)
+	}
+
+	if _, err := r.Mutation().CreateAPIToken(alice, "bot", []string{"posts:admin"}, nil); err == nil || !strings.Contains(err.Error(), "invalid scope") {
+		t.Fatalf("expected invalid scope, got %v", err)
+	}
+	created, err := r.Mutation().CreateAPIToken(alice, "bot", []string{"comments:write", "comments:write"}, nil)
+	if err != nil || len(created.APIToken.Scopes) != 1 {
+		t.Fatalf("create token: %+v %v", created, err)
+	}
+
+	p, err := authenticate(created.Token)
+	if err != nil || p == nil || p.Name != "alice" || p.TokenID != created.APIToken.ID {
+		t.Fatalf("authenticate: %+v %v", p, err)
+	}
+	bot := auth.Into(context.Background(), p)
+
+	post, err := r.Mutation().CreatePost(alice, "t", "b", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	if _, err := r.Mutation().CreatePost(bot, "t", "b", nil, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "posts:write") {
+		t.Fatalf("expected missing posts:write scope, got %v", err)
+	}
+	c, err := r.Mutation().AddComment(bot, post.ID, nil, "from bot", nil, nil)
+	if err != nil || c.Author != "alice" {
+		t.Fatalf("comment via token: %+v %v", c, err)
+	}
+	if _, err := r.Mutation().CreateAPIToken(bot, "more", []string{"moderate"}, nil); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("token must not create tokens, got %v", err)
+	}
+
+	v, _ := r.Query().Viewer(alice)
+	tokens, err := r.Viewer().APITokens(alice, v)
+	if err != nil || len(tokens) != 1 || tokens[0].Hash == created.Token {
+		t.Fatalf("viewer tokens: %+v %v", tokens, err)
+	}
+
+	bob := auth.Into(context.Background(), &auth.Principal{Name: "bob"})
+	if _, err := r.Mutation().RevokeAPIToken(bob, created.APIToken.ID); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("other user must not revoke token, got %v", err)
+	}
+	if revoked, err := r.Mutation().RevokeAPIToken(alice, created.APIToken.ID); err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("revoke: %+v %v", revoked, err)
+	}
+	if _, err := authenticate(created.Token); !errors.Is(err, auth.ErrUnauthorized) {
+		t.Fatalf("revoked token must be rejected, got %v", err)
+	}
+}
+
+func TestModeration_Roles(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+	as := func(name string) context.Context { return auth.Into(ctx, &auth.Principal{Name: name}) }
+
+	post, err := r.Mutation().CreatePost(as("alice"), "t", "b", nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	root, err := r.Mutation().AddComment(as("bob"), post.ID, nil, "root", nil, nil)
+	if err != nil {
+		t.Fatalf("add comment: %v", err)
+	}
+	reply, err := r.Mutation().AddComment(as("carol"), post.ID, &root.ID, "reply", nil, nil)
+	if err != nil {
+		t.Fatalf("add reply: %v", err)
+	}
+	if _, err := r.Store.SetUserRole(ctx, "mod", model.RoleModerator, time.Now()); err != nil {
+		t.Fatalf("set role: %v", err)
+	}
+
+	// чужой комментарий и чужой пост модератор правит теми же мутациями, что и автор
+	if _, err := r.Mutation().DeleteComment(as("bob"), reply.ID, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("user must not delete other comment, got %v", err)
+	}
+	deleted, err := r.Mutation().DeleteComment(as("mod"), reply.ID, nil, nil, nil)
+	if err != nil || !deleted.Deleted {
+		t.Fatalf("moderator delete: %+v %v", deleted, err)
+	}
+	if _, err := r.Mutation().ToggleCommentsClosed(as("bob"), post.ID, true, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("user must not close comments, got %v", err)
+	}
+
+	// директива проверяется самой схемой, а не резолверами
+	srv := handler.New(graph.NewExecutableSchema(r))
+	srv.AddTransport(transport.POST{})
+	c := client.New(auth.Middleware(auth.Header("X-User"))(srv))
+	const lockThread = `mutation($id: ID!) { lockThread(commentId: $id, locked: true) { locked } }`
+
+	if err := c.Post(lockThread, &struct{}{}, client.Var("id", root.ID)); err == nil || !strings.Contains(err.Error(), "auth is required") {
+		t.Fatalf("anonymous must not moderate, got %v", err)
+	}
+	if err := c.Post(lockThread, &struct{}{}, client.Var("id", root.ID), client.AddHeader("X-User", "bob")); err == nil || !strings.Contains(err.Error(), "MODERATOR role is required") {
+		t.Fatalf("user must not moderate, got %v", err)
+	}
+	var resp struct{ LockThread struct{ Locked bool } }
+	if err := c.Post(lockThread, &resp, client.Var("id", root.ID), client.AddHeader("X-User", "mod")); err != nil || !resp.LockThread.Locked {
+		t.Fatalf("lock thread: %+v %v", resp, err)
+	}
+	if err := c.Post(`mutation { setUserRole(user: "bob", role: MODERATOR) { role } }`, &struct{}{}, client.AddHeader("X-User", "mod")); err == nil || !strings.Contains(err.Error(), "ADMIN role is required") {
+		t.Fatalf("moderator must not assign roles, got %v", err)
+	}
+
+	// закрытая ветка не принимает ответов ни на корень, ни глубже
+	if _, err := r.Mutation().AddComment(as("alice"), post.ID, &root.ID, "more", nil, nil); err == nil || !strings.Contains(err.Error(), "thread is locked") {
+		t.Fatalf("expected locked thread, got %v", err)
+	}
+	if _, err := r.Mutation().AddComment(as("alice"), post.ID, nil, "new thread", nil, nil); err != nil {
+		t.Fatalf("other threads stay open: %v", err)
+	}
+
+	closed, err := r.Mutation().ToggleCommentsClosed(as("mod"), post.ID, true, nil, nil, nil)
+	if err != nil || !closed.CommentsClosed {
+		t.Fatalf("moderator close comments: %+v %v", closed, err)
+	}
+}
+
+func TestDeletePost_ClosesSubscriptions(t *testing.T) {
+	r := newResolverForTests()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+
+	ch, err := r.Subscription().CommentAdded(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	if _, err := r.Mutation().DeletePost(ctx, p.ID, strPtr("bob"), nil, nil); err == nil {
+		t.Fatal("expected forbidden for non-author")
+	}
+	if _, err := r.Mutation().DeletePost(ctx, p.ID, strPtr("u"), nil, nil); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected subscription channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed after post deletion")
+	}
+
+	if _, err := r.Query().Post(ctx, p.ID, nil); err == nil {
+		t.Fatal("expected deleted post to be gone")
+	}
+}
+
+func TestReactions_ReactAndUnreact(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	c, _ := r.Mutation().AddComment(ctx, p.ID, nil, "c", strPtr("bob"), nil)
+
+	if _, err := r.Mutation().React(ctx, c.ID, model.ReactionKindHeart, strPtr("alice"), nil); err != nil {
+		t.Fatalf("react: %v", err)
+	}
+	// повторная реакция того же вида не удваивает счётчик
+	if _, err := r.Mutation().React(ctx, c.ID, model.ReactionKindHeart, strPtr("alice"), nil); err != nil {
+		t.Fatalf("react again: %v", err)
+	}
+	counts, err := r.Mutation().React(ctx, c.ID, model.ReactionKindHeart, strPtr("bob"), nil)
+	if err != nil {
+		t.Fatalf("react bob: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Count != 2 || !counts[0].ViewerHasReacted {
+		t.Fatalf("unexpected counts: %+v", counts[0])
+	}
+
+	if _, err := r.Mutation().React(ctx, p.ID, model.ReactionKindThumbsUp, strPtr("bob"), nil); err != nil {
+		t.Fatalf("react post: %v", err)
+	}
+	if _, err := r.Mutation().React(ctx, "missing", model.ReactionKindThumbsUp, strPtr("bob"), nil); err == nil {
+		t.Fatal("expected unknown target error")
+	}
+
+	counts, err = r.Mutation().Unreact(ctx, c.ID, model.ReactionKindHeart, strPtr("bob"), nil)
+	if err != nil {
+		t.Fatalf("unreact: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Count != 1 || counts[0].ViewerHasReacted {
+		t.Fatalf("unexpected counts after unreact: %+v", counts[0])
+	}
+
+	viewer := "alice"
+	counts, err = r.Post().Reactions(ctx, p, &viewer)
+	if err != nil {
+		t.Fatalf("post reactions: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Kind != model.ReactionKindThumbsUp || counts[0].ViewerHasReacted {
+		t.Fatalf("unexpected post reactions: %+v", counts)
+	}
+}
+
+type countingStore struct {
+	store.Store
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *countingStore) BatchReactions(ctx context.Context, targetIDs []string, viewer string) (map[string][]*model.ReactionCount, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	return s.Store.BatchReactions(ctx, targetIDs, viewer)
+}
+
+func TestReactions_BatchedThroughLoader(t *testing.T) {
+	st := &countingStore{Store: store.NewMemStore()}
+	r := &graph.Resolver{Store: st, Bus: pubsub.NewMemoryBus(), AllowUserArgs: true}
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	var comments []*model.Comment
+	for i := 0; i < 50; i++ {
+		c, err := r.Mutation().AddComment(ctx, p.ID, nil, "c", strPtr("bob"), nil)
+		if err != nil {
+			t.Fatalf("add comment: %v", err)
+		}
+		comments = append(comments, c)
+	}
+
+	graph.WithLoaders(st, func(ctx context.Context) {
+		var wg sync.WaitGroup
+		for _, c := range comments {
+			wg.Add(1)
+			go func(c *model.Comment) {
+				defer wg.Done()
+				if _, err := r.Comment().Reactions(ctx, c, nil); err != nil {
+					t.Errorf("reactions: %v", err)
+				}
+			}(c)
+		}
+		wg.Wait()
+	})(ctx)
+
+	// допускаем отставший второй батч на медленной машине, но не запрос на каждый комментарий
+	if st.calls > 2 {
+		t.Fatalf("expected reactions to be batched, got %d store calls", st.calls)
+	}
+}
+
+func TestComments_OrderBy(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	a, _ := r.Mutation().AddComment(ctx, p.ID, nil, "a", strPtr("x"), nil)
+	time.Sleep(time.Millisecond)
+	b, _ := r.Mutation().AddComment(ctx, p.ID, nil, "b", strPtr("x"), nil)
+	time.Sleep(time.Millisecond)
+	a1, _ := r.Mutation().AddComment(ctx, p.ID, &a.ID, "a1", strPtr("x"), nil)
+	_, _ = r.Mutation().React(ctx, b.ID, model.ReactionKindHeart, strPtr("y"), nil)
+	_, _ = r.Mutation().React(ctx, b.ID, model.ReactionKindLaugh, strPtr("y"), nil)
+
+	ids := func(order model.CommentOrder) []string {
+		t.Helper()
+		conn, err := r.Query().Comments(ctx, p.ID, nil, nil, nil, nil, nil, &order)
+		if err != nil {
+			t.Fatalf("comments %s: %v", order, err)
+		}
+		var out []string
+		for _, e := range conn.Edges {
+			out = append(out, e.Node.Body)
+		}
+		return out
+	}
+
+	for order, want := range map[model.CommentOrder]string{
+		model.CommentOrderOldest:   "a,b,a1",
+		model.CommentOrderNewest:   "a1,b,a",
+		model.CommentOrderTop:      "b,a,a1",
+		model.CommentOrderThreaded: "a,a1,b",
+	} {
+		if got := strings.Join(ids(order), ","); got != want {
+			t.Errorf("%s: want %s got %s", order, want, got)
+		}
+	}
+
+	// курсор одного порядка не принимается в другом
+	first := 1
+	top := model.CommentOrderTop
+	conn, err := r.Query().Comments(ctx, p.ID, nil, nil, &first, nil, nil, &top)
+	if err != nil {
+		t.Fatalf("top page: %v", err)
+	}
+	next, err := r.Query().Comments(ctx, p.ID, nil, conn.PageInfo.EndCursor, &first, nil, nil, &top)
+	if err != nil || len(next.Edges) != 1 || next.Edges[0].Node.ID != a.ID {
+		t.Fatalf("expected second TOP page with a, got %v %v", next, err)
+	}
+	newest := model.CommentOrderNewest
+	if _, err := r.Query().Comments(ctx, p.ID, nil, conn.PageInfo.EndCursor, &first, nil, nil, &newest); err == nil {
+		t.Fatal("expected cursor from TOP to be rejected under NEWEST")
+	}
+
+	threaded := model.CommentOrderThreaded
+	conn, err = r.Query().Comments(ctx, p.ID, &a.ID, nil, nil, nil, nil, &threaded)
+	if err != nil || len(conn.Edges) != 1 || conn.Edges[0].Node.ID != a1.ID {
+		t.Fatalf("expected threaded subtree of a, got %v %v", conn, err)
+	}
+}
+
+func TestComments_BackwardPagination(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	for _, body := range []string{"c1", "c2", "c3", "c4", "c5"} {
+		if _, err := r.Mutation().AddComment(ctx, p.ID, nil, body, strPtr("x"), nil); err != nil {
+			t.Fatalf("add comment: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	bodies := func(conn *model.CommentPage) string {
+		var out []string
+		for _, e := range conn.Edges {
+			out = append(out, e.Node.Body)
+		}
+		return strings.Join(out, ",")
+	}
+
+	// последние два комментария, порядок внутри страницы сохраняется
+	last := 2
+	tail, err := r.Query().Comments(ctx, p.ID, nil, nil, nil, nil, &last, nil)
+	if err != nil {
+		t.Fatalf("last page: %v", err)
+	}
+	if got := bodies(tail); got != "c4,c5" {
+		t.Fatalf("want c4,c5 got %s", got)
+	}
+	if tail.TotalCount != 5 || !tail.PageInfo.HasPreviousPage || tail.PageInfo.HasNextPage {
+		t.Fatalf("unexpected page info: total=%d %+v", tail.TotalCount, tail.PageInfo)
+	}
+
+	prev, err := r.Query().Comments(ctx, p.ID, nil, nil, nil, tail.PageInfo.StartCursor, &last, nil)
+	if err != nil {
+		t.Fatalf("previous page: %v", err)
+	}
+	if got := bodies(prev); got != "c2,c3" {
+		t.Fatalf("want c2,c3 got %s", got)
+	}
+	if !prev.PageInfo.HasPreviousPage || !prev.PageInfo.HasNextPage {
+		t.Fatalf("expected pages on both sides: %+v", prev.PageInfo)
+	}
+
+	// before сужает выборку, first берёт её начало
+	first := 2
+	beforeFirst, err := r.Query().Comments(ctx, p.ID, nil, nil, &first, tail.PageInfo.StartCursor, nil, nil)
+	if err != nil || bodies(beforeFirst) != "c1,c2" || beforeFirst.PageInfo.HasPreviousPage || !beforeFirst.PageInfo.HasNextPage {
+		t.Fatalf("expected c1,c2 before c4, got %v %v", beforeFirst, err)
+	}
+
+	// first и last вместе: последние last из первых first
+	four, one := 4, 1
+	firstLast, err := r.Query().Comments(ctx, p.ID, nil, nil, &four, nil, &one, nil)
+	if err != nil || bodies(firstLast) != "c4" || !firstLast.PageInfo.HasPreviousPage || !firstLast.PageInfo.HasNextPage {
+		t.Fatalf("expected c4 as last of first four, got %v %v", firstLast, err)
+	}
+	if *firstLast.PageInfo.StartCursor != *firstLast.PageInfo.EndCursor {
+		t.Fatalf("cursors must describe the trimmed page: %+v", firstLast.PageInfo)
+	}
+
+	// у первой страницы нет предыдущей, в какую бы сторону ни листать
+	fwd, err := r.Query().Comments(ctx, p.ID, nil, nil, &first, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if fwd.PageInfo.HasPreviousPage || !fwd.PageInfo.HasNextPage || fwd.TotalCount != 5 {
+		t.Fatalf("unexpected first page info: %+v", fwd.PageInfo)
+	}
+	back, err := r.Query().Comments(ctx, p.ID, nil, nil, nil, prev.PageInfo.StartCursor, &last, nil)
+	if err != nil || bodies(back) != "c1" || back.PageInfo.HasPreviousPage {
+		t.Fatalf("expected c1 as the very first page, got %v %v", back, err)
+	}
+}
+
+func TestPostTags(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	p, err := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), []string{"  Go ", "go", "Web  Dev"}, nil, nil)
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	tags, err := r.Post().Tags(ctx, p)
+	if err != nil || strings.Join(tags, ",") != "go,web-dev" {
+		t.Fatalf("expected normalised tags go,web-dev, got %v %v", tags, err)
+	}
+	other, _ := r.Mutation().CreatePost(ctx, "t2", "b", strPtr("u"), []string{"go"}, nil, nil)
+
+	many := make([]string, 11)
+	for i := range many {
+		many[i] = strings.Repeat("x", i+1)
+	}
+	if _, err := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), many, nil, nil); err == nil {
+		t.Fatal("expected too many tags to be rejected")
+	}
+	if _, err := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), []string{"   "}, nil, nil); err == nil {
+		t.Fatal("expected empty tag to be rejected")
+	}
+
+	list, err := r.Query().Tags(ctx, nil)
+	if err != nil || len(list) != 2 || list[0].Name != "go" || list[0].PostsCount != 2 {
+		t.Fatalf("unexpected tag list: %v %v", list, err)
+	}
+
+	tag := " GO"
+	page, err := r.Query().Posts(ctx, nil, nil, nil, nil, nil, nil, &tag, nil)
+	if err != nil || len(page.Edges) != 2 {
+		t.Fatalf("expected 2 posts tagged go, got %v %v", page, err)
+	}
+
+	// пустой список снимает все теги, nil оставляет как есть
+	if _, err := r.Mutation().UpdatePost(ctx, other.ID, nil, nil, []string{}, strPtr("u"), nil, nil); err != nil {
+		t.Fatalf("clear tags: %v", err)
+	}
+	if _, err := r.Mutation().UpdatePost(ctx, p.ID, nil, nil, nil, strPtr("u"), nil, nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	page, _ = r.Query().Posts(ctx, nil, nil, nil, nil, nil, nil, &tag, nil)
+	if len(page.Edges) != 1 || page.Edges[0].Node.ID != p.ID {
+		t.Fatalf("expected only the first post tagged go, got %d", len(page.Edges))
+	}
+}
+
+func TestDraftPosts(t *testing.T) {
+	r := newResolverForTests()
+	ctx := context.Background()
+
+	draft := true
+	p, err := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), []string{"secret"}, &draft, nil)
+	if err != nil || p.Status != model.PostStatusDraft {
+		t.Fatalf("expected draft, got %v %v", p, err)
+	}
+	if tags, _ := r.Query().Tags(ctx, nil); len(tags) != 0 {
+		t.Fatalf("expected draft tags to be hidden, got %+v", tags)
+	}
+
+	viewer := "u"
+	if _, err := r.Query().Post(ctx, p.ID, nil); err == nil {
+		t.Fatal("expected draft to be hidden from strangers")
+	}
+	if got, err := r.Query().Post(ctx, p.ID, &viewer); err != nil || got.ID != p.ID {
+		t.Fatalf("expected author to see draft, got %v %v", got, err)
+	}
+	if page, _ := r.Query().Posts(ctx, nil, nil, nil, nil, nil, nil, nil, nil); len(page.Edges) != 0 {
+		t.Fatalf("expected draft to be hidden from feed, got %d", len(page.Edges))
+	}
+	if _, err := r.Mutation().AddComment(ctx, p.ID, nil, "c", strPtr("x"), nil); err == nil {
+		t.Fatal("expected comments on draft to be rejected")
+	}
+
+	if _, err := r.Mutation().SchedulePost(ctx, p.ID, time.Now().Add(-time.Minute), strPtr("u"), nil, nil); err == nil {
+		t.Fatal("expected past publish time to be rejected")
+	}
+	scheduled, err := r.Mutation().SchedulePost(ctx, p.ID, time.Now().Add(time.Hour), strPtr("u"), nil, nil)
+	if err != nil || scheduled.Status != model.PostStatusScheduled || scheduled.PublishAt == nil {
+		t.Fatalf("schedule: %v %v", scheduled, err)
+	}
+
+	if _, err := r.Mutation().PublishPost(ctx, p.ID, strPtr("other"), nil, nil); err == nil {
+		t.Fatal("expected forbidden for non-author")
+	}
+	published, err := r.Mutation().PublishPost(ctx, p.ID, strPtr("u"), nil, nil)
+	if err != nil || published.Status != model.PostStatusPublished {
+		t.Fatalf("publish: %v %v", published, err)
+	}
+	if page, _ := r.Query().Posts(ctx, nil, nil, nil, nil, nil, nil, nil, nil); len(page.Edges) != 1 {
+		t.Fatalf("expected published post in feed, got %d", len(page.Edges))
+	}
+	if tags, _ := r.Query().Tags(ctx, nil); len(tags) != 1 || tags[0].PostsCount != 1 {
+		t.Fatalf("expected tag of published post, got %+v", tags)
+	}
+}
+
+func TestAddComment_MaxDepthFlattens(t *testing.T) {
+	r := newResolverForTests()
+	r.MaxCommentDepth = 1
+	ctx := context.Background()
+
+	p, _ := r.Mutation().CreatePost(ctx, "t", "b", strPtr("u"), nil, nil, nil)
+	root, _ := r.Mutation().AddComment(ctx, p.ID, nil, "root", strPtr("a"), nil)
+	child, _ := r.Mutation().AddComment(ctx, p.ID, &root.ID, "child", strPtr("b"), nil)
+	if child.Depth != 1 || *child.ReplyToID != root.ID {
+		t.Fatalf("unexpected child: %+v", child)
+	}
+
+	// ответ на комментарий на пределе глубины встаёт рядом с ним
+	reply, err := r.Mutation().AddComment(ctx, p.ID, &child.ID, "reply", strPtr("a"), nil)
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if reply.Depth != 1 || *reply.ParentID != root.ID || *reply.ReplyToID != child.ID {
+		t.Fatalf("expected reply flattened under root, got parent=%v replyTo=%v depth=%d",
+			*reply.ParentID, *reply.ReplyToID, reply.Depth)
+	}
+
+	// если лимит уменьшили, ответ поднимается на нужный уровень
+	r.MaxCommentDepth = 2
+	deep, _ := r.Mutation().AddComment(ctx, p.ID, &reply.ID, "deep", strPtr("b"), nil)
+	r.MaxCommentDepth = 1
+	deeper, err := r.Mutation().AddComment(ctx, p.ID, &deep.ID, "deeper", strPtr("a"), nil)
+	if err != nil || deeper.Depth != 1 || *deeper.ParentID != root.ID || *deeper.ReplyToID != deep.ID {
+		t.Fatalf("expected deeper reply under root, got %+v %v", deeper, err)
+	}
+}
@@ -7,156 +7,815 @@ package graph
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/generated"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/logctx"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
 	"github.com/google/uuid"
 )
 
+// ID is the resolver for the id field.
+func (r *commentResolver) ID(ctx context.Context, obj *model.Comment) (string, error) {
+	return globalID(nodeComment, obj.ID), nil
+}
+
+// PostID is the resolver for the postID field.
+func (r *commentResolver) PostID(ctx context.Context, obj *model.Comment) (string, error) {
+	return globalID(nodePost, obj.PostID), nil
+}
+
+// ParentID is the resolver for the parentID field.
+func (r *commentResolver) ParentID(ctx context.Context, obj *model.Comment) (*string, error) {
+	return globalIDPtr(nodeComment, obj.ParentID), nil
+}
+
+// ReplyToID is the resolver for the replyToID field.
+func (r *commentResolver) ReplyToID(ctx context.Context, obj *model.Comment) (*string, error) {
+	return globalIDPtr(nodeComment, obj.ReplyToID), nil
+}
+
+// Author is the resolver for the author field.
+func (r *commentResolver) Author(ctx context.Context, obj *model.Comment) (*model.User, error) {
+	if obj.Deleted || obj.Author == "" {
+		return nil, nil
+	}
+	return r.loadUser(ctx, obj.Author)
+}
+
+// Revisions is the resolver for the revisions field.
+func (r *commentResolver) Revisions(ctx context.Context, obj *model.Comment) ([]*model.CommentRevision, error) {
+	if obj.Deleted {
+		return []*model.CommentRevision{}, nil
+	}
+	return r.Store.ListCommentRevisions(ctx, obj.ID)
+}
+
+// Reactions is the resolver for the reactions field.
+func (r *commentResolver) Reactions(ctx context.Context, obj *model.Comment, viewer *string) ([]*model.ReactionCount, error) {
+	return r.reactionCounts(ctx, obj.ID, viewer)
+}
+
 // CreatePost is the resolver for the createPost field.
-func (r *mutationResolver) CreatePost(ctx context.Context, title string, body string, author string) (*model.Post, error) {
-	if author == "" {
-		return nil, errors.New("author is required")
+func (r *mutationResolver) CreatePost(ctx context.Context, title string, body string, author *string, tags []string, draft *bool, idempotencyKey *string) (*model.Post, error) {
+	caller, err := r.actor(ctx, author, auth.ScopePostsWrite)
+	if err != nil {
+		return nil, err
 	}
+
 	if title == "" {
 		return nil, errors.New("title is required")
 	}
 	if len(body) == 0 {
 		return nil, errors.New("body is required")
 	}
+	tags, err = normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
 
 	newPost := &model.Post{
 		ID:             uuid.NewString(),
 		Title:          title,
 		Body:           body,
-		Author:         author,
+		Author:         caller,
 		CommentsClosed: false,
 		CreatedAt:      time.Now().UTC(),
+		Status:         model.PostStatusPublished,
+	}
+	if draft != nil && *draft {
+		newPost.Status = model.PostStatusDraft
 	}
-	if err := r.Store.CreatePost(ctx, newPost); err != nil {
+	_, err = r.withIdempotency(ctx, "createPost", caller, idempotencyKey, newPost, func(tx store.Store) error {
+		if err := tx.CreatePost(ctx, newPost); err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			return tx.SetPostTags(ctx, newPost.ID, tags)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return newPost, nil
 }
 
-// ToggleCommentsClosed is the resolver for the toggleCommentsClosed field.
-func (r *mutationResolver) ToggleCommentsClosed(ctx context.Context, postID string, closed bool, user string) (*model.Post, error) {
-	post, err := r.Store.GetPost(ctx, postID)
+// UpdatePost is the resolver for the updatePost field.
+func (r *mutationResolver) UpdatePost(ctx context.Context, id string, title *string, body *string, tags []string, user *string, expectedVersion *int, idempotencyKey *string) (*model.Post, error) {
+	caller, err := r.actor(ctx, user, auth.ScopePostsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err = localID(nodePost, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if title != nil && *title == "" {
+		return nil, errors.New("title is required")
+	}
+	if body != nil && len(*body) == 0 {
+		return nil, errors.New("body is required")
+	}
+	if tags != nil {
+		if tags, err = normalizeTags(tags); err != nil {
+			return nil, err
+		}
+	}
 
+	var post *model.Post
+	_, err = r.withIdempotency(ctx, "updatePost", caller, idempotencyKey, &post, func(tx store.Store) error {
+		current, err := tx.GetPost(ctx, id)
+		if err != nil {
+			return err
+		}
+		if caller != current.Author {
+			return errors.New("forbidden: only post author can edit post")
+		}
+
+		if post, err = tx.UpdatePost(ctx, id, title, body, expectedVersion); err != nil {
+			return err
+		}
+		if tags != nil {
+			return tx.SetPostTags(ctx, id, tags)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if post == nil {
-		return nil, errors.New("post not found")
+	return post, nil
+}
+
+// DeletePost is the resolver for the deletePost field.
+func (r *mutationResolver) DeletePost(ctx context.Context, id string, user *string, expectedVersion *int, idempotencyKey *string) (string, error) {
+	caller, err := r.actor(ctx, user, auth.ScopePostsWrite)
+	if err != nil {
+		return "", err
+	}
+
+	id, err = localID(nodePost, id)
+	if err != nil {
+		return "", err
 	}
 
-	if user == "" || user != post.Author {
-		return nil, errors.New("forbidden: only post author can toggle comments")
+	_, err = r.withIdempotency(ctx, "deletePost", caller, idempotencyKey, &id, func(tx store.Store) error {
+		post, err := tx.GetPost(ctx, id)
+		if err != nil {
+			return err
+		}
+		if caller != post.Author {
+			return errors.New("forbidden: only post author can delete post")
+		}
+		return tx.DeletePost(ctx, id, expectedVersion)
+	})
+	if err != nil {
+		return "", err
 	}
 
-	post, err = r.Store.CloseComments(ctx, postID, closed)
+	// завершаем подписки commentAdded на удалённый пост
+	r.Bus.CloseTopic(id)
+	return globalID(nodePost, id), nil
+}
+
+// PublishPost is the resolver for the publishPost field.
+func (r *mutationResolver) PublishPost(ctx context.Context, id string, user *string, expectedVersion *int, idempotencyKey *string) (*model.Post, error) {
+	caller, err := r.actor(ctx, user, auth.ScopePostsWrite)
 	if err != nil {
 		return nil, err
 	}
 
+	id, err = localID(nodePost, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var post *model.Post
+	_, err = r.withIdempotency(ctx, "publishPost", caller, idempotencyKey, &post, func(tx store.Store) error {
+		var err error
+		if post, err = tx.GetPost(ctx, id); err != nil {
+			return err
+		}
+		if caller != post.Author {
+			return errors.New("forbidden: only post author can publish post")
+		}
+		// повторная публикация ничего не меняет
+		if post.Status == model.PostStatusPublished {
+			if expectedVersion != nil && *expectedVersion != post.Version {
+				return &store.ConflictError{Expected: *expectedVersion, Actual: post.Version}
+			}
+			return nil
+		}
+
+		now := time.Now().UTC()
+		post, err = tx.SetPostStatus(ctx, id, model.PostStatusPublished, &now, expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 	return post, nil
 }
 
-// AddComment is the resolver for the addComment field.
-func (r *mutationResolver) AddComment(ctx context.Context, postID string, parentID *string, body string, author string) (*model.Comment, error) {
-	if author == "" {
-		return nil, errors.New("auth is required")
+// SchedulePost is the resolver for the schedulePost field.
+func (r *mutationResolver) SchedulePost(ctx context.Context, id string, at time.Time, user *string, expectedVersion *int, idempotencyKey *string) (*model.Post, error) {
+	caller, err := r.actor(ctx, user, auth.ScopePostsWrite)
+	if err != nil {
+		return nil, err
 	}
 
-	post, err := r.Store.GetPost(ctx, postID)
+	id, err = localID(nodePost, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if post == nil {
-		return nil, errors.New("post not found")
+	var post *model.Post
+	_, err = r.withIdempotency(ctx, "schedulePost", caller, idempotencyKey, &post, func(tx store.Store) error {
+		current, err := tx.GetPost(ctx, id)
+		if err != nil {
+			return err
+		}
+		if caller != current.Author {
+			return errors.New("forbidden: only post author can schedule post")
+		}
+		if current.Status == model.PostStatusPublished {
+			return errors.New("invalid status: post is already published")
+		}
+		if !at.After(time.Now()) {
+			return errors.New("invalid publish time: must be in the future")
+		}
+
+		at = at.UTC()
+		post, err = tx.SetPostStatus(ctx, id, model.PostStatusScheduled, &at, expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if post.CommentsClosed {
-		return nil, errors.New("comments are closed for this post")
+	return post, nil
+}
+
+// ToggleCommentsClosed is the resolver for the toggleCommentsClosed field.
+func (r *mutationResolver) ToggleCommentsClosed(ctx context.Context, postID string, closed bool, user *string, expectedVersion *int, idempotencyKey *string) (*model.Post, error) {
+	caller, err := r.actor(ctx, user, auth.ScopePostsWrite)
+	if err != nil {
+		return nil, err
 	}
 
-	body = strings.TrimSpace(body)
-	if body == "" {
-		return nil, errors.New("comment body is required")
+	postID, err = localID(nodePost, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	var post *model.Post
+	_, err = r.withIdempotency(ctx, "toggleCommentsClosed", caller, idempotencyKey, &post, func(tx store.Store) error {
+		current, err := tx.GetPost(ctx, postID)
+		if err != nil {
+			return err
+		}
+		err = authorize(ctx, tx, caller, "forbidden: only post author or moderator can toggle comments", current.Author)
+		if err != nil {
+			return err
+		}
+
+		post, err = tx.CloseComments(ctx, postID, closed, expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if len(body) > maxCommentLen {
-		return nil, fmt.Errorf("comment body is too long (max %d)", maxCommentLen)
+	return post, nil
+}
+
+// AddComment is the resolver for the addComment field.
+func (r *mutationResolver) AddComment(ctx context.Context, postID string, parentID *string, body string, author *string, idempotencyKey *string) (*model.Comment, error) {
+	caller, err := r.actor(ctx, author, auth.ScopeCommentsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	postID, err = localID(nodePost, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = normalizeCommentBody(body)
+	if err != nil {
+		return nil, err
 	}
 
 	if parentID != nil && *parentID == "" {
 		parentID = nil
 	}
+	if parentID, err = localIDPtr(nodeComment, parentID); err != nil {
+		return nil, err
+	}
 
-	if parentID != nil {
-		parent, err := r.Store.GetComment(ctx, *parentID)
+	// replyToID — на какой комментарий отвечали, parentID — куда ответ попадёт в ветке
+	comment := &model.Comment{
+		ID:        uuid.NewString(),
+		PostID:    postID,
+		ReplyToID: parentID,
+		Author:    caller,
+		Body:      body,
+	}
+
+	// пост блокируется до вставки: закрыть комментарии между проверкой и записью не получится
+	replayed, err := r.withIdempotency(ctx, "addComment", caller, idempotencyKey, comment, func(tx store.Store) error {
+		post, err := tx.GetPost(ctx, postID)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if parent == nil || parent.PostID != postID {
-			return nil, errors.New("invalid parentId")
+		if post.Status != model.PostStatusPublished {
+			return errors.New("forbidden: post is not published")
 		}
+		if post.CommentsClosed {
+			return errors.New("comments are closed for this post")
+		}
+
+		if parentID != nil {
+			parent, err := tx.GetComment(ctx, *parentID)
+			if err != nil {
+				return err
+			}
+			if parent.PostID != postID {
+				return errors.New("invalid parentId")
+			}
+			locked, err := threadLocked(ctx, tx, parent)
+			if err != nil {
+				return err
+			}
+			if locked {
+				return errors.New("forbidden: thread is locked")
+			}
+			if parent, err = r.replyParent(ctx, tx, parent); err != nil {
+				return err
+			}
+			comment.ParentID = &parent.ID
+		}
+
+		comment.CreatedAt = time.Now().UTC()
+		return tx.CreateComment(ctx, comment)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	comment := &model.Comment{
+	// повтор запроса не рассылает комментарий подписчикам второй раз
+	if !replayed {
+		go r.Bus.Publish(postID, *comment)
+	}
+	return comment, nil
+}
+
+// EditComment is the resolver for the editComment field.
+func (r *mutationResolver) EditComment(ctx context.Context, id string, body string, user *string, expectedVersion *int, idempotencyKey *string) (*model.Comment, error) {
+	caller, err := r.actor(ctx, user, auth.ScopeCommentsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err = localID(nodeComment, id)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = normalizeCommentBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment *model.Comment
+	_, err = r.withIdempotency(ctx, "editComment", caller, idempotencyKey, &comment, func(tx store.Store) error {
+		current, err := tx.GetComment(ctx, id)
+		if err != nil {
+			return err
+		}
+		if caller != current.Author {
+			return errors.New("forbidden: only comment author can edit it")
+		}
+		if current.Deleted {
+			return errors.New("forbidden: comment is deleted")
+		}
+
+		now := time.Now().UTC()
+		if r.CommentEditWindow > 0 && now.Sub(current.CreatedAt) > r.CommentEditWindow {
+			return errors.New("forbidden: edit window has expired")
+		}
+
+		comment, err = tx.UpdateCommentBody(ctx, id, body, now, expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// DeleteComment is the resolver for the deleteComment field.
+func (r *mutationResolver) DeleteComment(ctx context.Context, id string, user *string, expectedVersion *int, idempotencyKey *string) (*model.Comment, error) {
+	caller, err := r.actor(ctx, user, auth.ScopeCommentsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err = localID(nodeComment, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment *model.Comment
+	_, err = r.withIdempotency(ctx, "deleteComment", caller, idempotencyKey, &comment, func(tx store.Store) error {
+		current, post, err := lockComment(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		// чужой комментарий удаляет автор поста как модератор своего поста или модератор сайта: обоим нужно право moderate
+		if caller != current.Author {
+			if err := requireScope(ctx, auth.ScopeModerate); err != nil {
+				return err
+			}
+			err = authorize(ctx, tx, caller, "forbidden: only comment or post author or moderator can delete comment", post.Author)
+			if err != nil {
+				return err
+			}
+		}
+
+		comment, err = tx.DeleteComment(ctx, id, time.Now().UTC(), expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// React is the resolver for the react field.
+func (r *mutationResolver) React(ctx context.Context, targetID string, kind model.ReactionKind, user *string, idempotencyKey *string) ([]*model.ReactionCount, error) {
+	caller, err := r.actor(ctx, user, auth.ScopeReactionsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	targetID = anyLocalID(targetID)
+	_, err = r.withIdempotency(ctx, "react", caller, idempotencyKey, nil, func(tx store.Store) error {
+		postID, commentID, err := reactionTarget(ctx, tx, targetID)
+		if err != nil {
+			return err
+		}
+		return tx.React(ctx, postID, commentID, caller, kind, time.Now().UTC())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := r.Store.BatchReactions(ctx, []string{targetID}, caller)
+	if err != nil {
+		return nil, err
+	}
+	return m[targetID], nil
+}
+
+// Unreact is the resolver for the unreact field.
+func (r *mutationResolver) Unreact(ctx context.Context, targetID string, kind model.ReactionKind, user *string, idempotencyKey *string) ([]*model.ReactionCount, error) {
+	caller, err := r.actor(ctx, user, auth.ScopeReactionsWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	targetID = anyLocalID(targetID)
+	_, err = r.withIdempotency(ctx, "unreact", caller, idempotencyKey, nil, func(tx store.Store) error {
+		return tx.Unreact(ctx, targetID, caller, kind)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := r.Store.BatchReactions(ctx, []string{targetID}, caller)
+	if err != nil {
+		return nil, err
+	}
+	return m[targetID], nil
+}
+
+// CreateAPIToken is the resolver for the createApiToken field.
+func (r *mutationResolver) CreateAPIToken(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (*model.APITokenCreated, error) {
+	owner, err := tokenOwner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxAPITokenNameLen {
+		return nil, errors.New("name is too long")
+	}
+	if scopes, err = normalizeScopes(scopes); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if expiresAt != nil {
+		if !expiresAt.After(now) {
+			return nil, errors.New("invalid expiresAt: must be in the future")
+		}
+		at := expiresAt.UTC()
+		expiresAt = &at
+	}
+
+	token, hash, err := auth.NewAPIToken()
+	if err != nil {
+		return nil, err
+	}
+	t := &model.APIToken{
 		ID:        uuid.NewString(),
-		PostID:    postID,
-		ParentID:  parentID,
-		Author:    author,
-		Body:      body,
-		CreatedAt: time.Now().UTC(),
+		User:      owner.Name,
+		Name:      name,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	err = r.Store.WithTx(ctx, func(tx store.Store) error {
+		if _, err := tx.EnsureUser(ctx, owner.Name, now); err != nil {
+			return err
+		}
+		return tx.CreateAPIToken(ctx, t)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &model.APITokenCreated{Token: token, APIToken: t}, nil
+}
 
-	if err := r.Store.CreateComment(ctx, comment); err != nil {
+// RevokeAPIToken is the resolver for the revokeApiToken field.
+func (r *mutationResolver) RevokeAPIToken(ctx context.Context, id string) (*model.APIToken, error) {
+	owner, err := tokenOwner(ctx)
+	if err != nil {
 		return nil, err
 	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("invalid id")
+	}
 
-	go r.Bus.Publish(postID, *comment)
+	t, err := r.Store.RevokeAPIToken(ctx, owner.Name, id, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errors.New("api token not found")
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// LockThread is the resolver for the lockThread field.
+func (r *mutationResolver) LockThread(ctx context.Context, commentID string, locked bool, expectedVersion *int, idempotencyKey *string) (*model.Comment, error) {
+	caller, err := r.actor(ctx, nil, auth.ScopeModerate)
+	if err != nil {
+		return nil, err
+	}
+
+	commentID, err = localID(nodeComment, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var comment *model.Comment
+	_, err = r.withIdempotency(ctx, "lockThread", caller, idempotencyKey, &comment, func(tx store.Store) error {
+		comment, err = tx.LockComment(ctx, commentID, locked, expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 	return comment, nil
 }
 
+// SetUserRole is the resolver for the setUserRole field.
+func (r *mutationResolver) SetUserRole(ctx context.Context, user string, role model.Role) (*model.User, error) {
+	caller, err := r.actor(ctx, nil, auth.ScopeModerate)
+	if err != nil {
+		return nil, err
+	}
+
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return nil, errors.New("user is required")
+	}
+	// иначе единственный администратор может случайно остаться без прав
+	if user == caller {
+		return nil, errors.New("forbidden: cannot change own role")
+	}
+	return r.Store.SetUserRole(ctx, user, role, time.Now().UTC())
+}
+
+// ID is the resolver for the id field.
+func (r *postResolver) ID(ctx context.Context, obj *model.Post) (string, error) {
+	return globalID(nodePost, obj.ID), nil
+}
+
+// Author is the resolver for the author field.
+func (r *postResolver) Author(ctx context.Context, obj *model.Post) (*model.User, error) {
+	return r.loadUser(ctx, obj.Author)
+}
+
+// Reactions is the resolver for the reactions field.
+func (r *postResolver) Reactions(ctx context.Context, obj *model.Post, viewer *string) ([]*model.ReactionCount, error) {
+	return r.reactionCounts(ctx, obj.ID, viewer)
+}
+
+// Tags is the resolver for the tags field.
+func (r *postResolver) Tags(ctx context.Context, obj *model.Post) ([]string, error) {
+	return r.postTags(ctx, obj.ID)
+}
+
 // Posts is the resolver for the posts field.
-func (r *queryResolver) Posts(ctx context.Context) ([]*model.Post, error) {
-	posts, err := r.Store.ListPosts(ctx)
+func (r *queryResolver) Posts(ctx context.Context, first *int, after *string, author *string, since *time.Time, until *time.Time, commentsClosed *bool, tag *string, viewer *string) (*model.PostPage, error) {
+	filter := store.PostFilter{
+		Author:         author,
+		Since:          since,
+		Until:          until,
+		CommentsClosed: commentsClosed,
+		Viewer:         r.viewer(ctx, viewer),
+	}
+	if tag != nil {
+		normalized, err := normalizeTag(*tag)
+		if err != nil {
+			return nil, err
+		}
+		filter.Tag = &normalized
+	}
+
+	postsPage, err := r.Store.ListPosts(ctx, filter, after, pageLimit(first))
 	if err != nil {
 		return nil, err
 	}
-	return posts, nil
+	return postsPage, nil
 }
 
 // Post is the resolver for the post field.
-func (r *queryResolver) Post(ctx context.Context, id string) (*model.Post, error) {
+func (r *queryResolver) Post(ctx context.Context, id string, viewer *string) (*model.Post, error) {
+	id, err := localID(nodePost, id)
+	if err != nil {
+		return nil, err
+	}
+
 	post, err := r.Store.GetPost(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	// чужой черновик неотличим от несуществующего поста
+	if !postVisible(post, r.viewer(ctx, viewer)) {
+		return nil, store.ErrNotFound
+	}
 	return post, nil
 }
 
+// Viewer is the resolver for the viewer field.
+func (r *queryResolver) Viewer(ctx context.Context) (*model.Viewer, error) {
+	p := auth.From(ctx)
+	if p == nil {
+		return nil, nil
+	}
+	user, err := r.Store.EnsureUser(ctx, p.Name, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	return &model.Viewer{User: user}, nil
+}
+
+// Node is the resolver for the node field.
+func (r *queryResolver) Node(ctx context.Context, id string, viewer *string) (model.Node, error) {
+	nodes, err := r.loadNodes(ctx, []string{id}, viewer)
+	if err != nil {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// Nodes is the resolver for the nodes field.
+func (r *queryResolver) Nodes(ctx context.Context, ids []string, viewer *string) ([]model.Node, error) {
+	if len(ids) > maxNodeIDs {
+		return nil, errors.New("invalid ids: too many")
+	}
+	return r.loadNodes(ctx, ids, viewer)
+}
+
+// Tags is the resolver for the tags field.
+func (r *queryResolver) Tags(ctx context.Context, first *int) ([]*model.Tag, error) {
+	return r.Store.ListTags(ctx, pageLimit(first))
+}
+
+// Search is the resolver for the search field.
+func (r *queryResolver) Search(ctx context.Context, query string, first *int, after *string) (*model.SearchPage, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, errors.New("search query is required")
+	}
+
+	return r.Store.Search(ctx, query, after, pageLimit(first))
+}
+
 // Comments is the resolver for the comments field.
-func (r *queryResolver) Comments(ctx context.Context, postID string, parentID *string, after *string, first *int) (*model.CommentPage, error) {
-	limit := 20
-	if first != nil && *first > 0 {
-		limit = *first
+func (r *queryResolver) Comments(ctx context.Context, postID string, parentID *string, after *string, first *int, before *string, last *int, orderBy *model.CommentOrder) (*model.CommentPage, error) {
+	postID, err := localID(nodePost, postID)
+	if err != nil {
+		return nil, err
 	}
 
-	commentsPage, err := r.Store.ListComments(ctx, postID, parentID, after, limit)
+	order := model.CommentOrderOldest
+	if orderBy != nil {
+		order = *orderBy
+	}
+	if parentID != nil && *parentID == "" {
+		parentID = nil
+	}
+	if parentID, err = localIDPtr(nodeComment, parentID); err != nil {
+		return nil, err
+	}
+
+	// порядок Relay: after и before сужают выборку, first берёт её начало, last — конец того, что осталось
+	limit, backward := pageLimit(first), first == nil && last != nil
+	if backward {
+		limit = pageLimit(last)
+	}
+
+	commentsPage, err := r.Store.ListComments(ctx, store.CommentsQuery{
+		PostID:   postID,
+		ParentID: parentID,
+		Order:    order,
+		After:    after,
+		Before:   before,
+		Limit:    limit,
+		Backward: backward,
+	})
 	if err != nil {
 		return nil, err
 	}
+	if first != nil && last != nil {
+		lastEdges(commentsPage, pageLimit(last))
+	}
 	return commentsPage, nil
 }
 
+// CommentTree is the resolver for the commentTree field.
+func (r *queryResolver) CommentTree(ctx context.Context, postID string, rootID *string, maxDepth *int, maxNodes *int) ([]*model.CommentTreeNode, error) {
+	postID, err := localID(nodePost, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	depth := defaultTreeDepth
+	if maxDepth != nil {
+		depth = *maxDepth
+	}
+	if depth < 0 {
+		return nil, errors.New("invalid maxDepth")
+	}
+
+	nodes := defaultTreeNodes
+	if maxNodes != nil {
+		nodes = *maxNodes
+	}
+	if nodes <= 0 {
+		return nil, errors.New("invalid maxNodes")
+	}
+	if nodes > maxTreeNodes {
+		nodes = maxTreeNodes
+	}
+
+	if rootID != nil && *rootID == "" {
+		rootID = nil
+	}
+	if rootID, err = localIDPtr(nodeComment, rootID); err != nil {
+		return nil, err
+	}
+
+	tree, err := r.Store.CommentTree(ctx, postID, rootID, depth, nodes)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errors.New("root comment not found")
+		}
+		return nil, err
+	}
+	return tree, nil
+}
+
 // CommentAdded is the resolver for the commentAdded field.
 func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string) (<-chan *model.Comment, error) {
+	postID, err := localID(nodePost, postID)
+	if err != nil {
+		return nil, err
+	}
+
 	log := logctx.From(ctx, r.Logger).With().
 		Str("op", "subscriptionForComment").
 		Str("postID", postID).
@@ -165,6 +824,9 @@ func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string)
 	log.Info().Str("postId", postID).Msg("subscription for post")
 
 	channel := make(chan *model.Comment, 1)
+	incoming := make(chan model.Comment, 16)
+	topicClosed := make(chan struct{})
+	var closeOnce sync.Once
 
 	unsubscribe := r.Bus.Subscribe(
 		postID,
@@ -172,30 +834,79 @@ func (r *subscriptionResolver) CommentAdded(ctx context.Context, postID string)
 			log.Debug().
 				Str("comment_id", comment.ID).
 				Msg("push")
-			commentCopy := comment
-			channel <- &commentCopy
+			select {
+			case incoming <- comment:
+			case <-topicClosed:
+			case <-ctx.Done():
+			}
 		},
+		func() { closeOnce.Do(func() { close(topicClosed) }) },
 	)
 
-	// отписать клиента, когда он отрубится
+	// отписать клиента, когда он отрубится или пост удалят; канал закрывает только эта горутина
 	go func() {
-		<-ctx.Done()
-		log.Info().Str("potsId", postID).Msg("unsubscribe from post")
-		unsubscribe()
-		close(channel)
+		defer close(channel)
+		defer unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info().Str("postId", postID).Msg("unsubscribe from post")
+				return
+			case <-topicClosed:
+				log.Info().Str("postId", postID).Msg("post deleted, subscription closed")
+				return
+			case comment := <-incoming:
+				select {
+				case channel <- &comment:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
 	}()
 	return channel, nil
 }
 
+// ID is the resolver for the id field.
+func (r *userResolver) ID(ctx context.Context, obj *model.User) (string, error) {
+	return globalID(nodeUser, obj.ID), nil
+}
+
+// APITokens is the resolver for the apiTokens field.
+func (r *viewerResolver) APITokens(ctx context.Context, obj *model.Viewer) ([]*model.APIToken, error) {
+	// список токенов — тоже управление ими, поэтому личному токену он недоступен
+	if _, err := tokenOwner(ctx); err != nil {
+		return nil, err
+	}
+	return r.Store.ListAPITokens(ctx, obj.User.Name)
+}
+
+// Comment returns generated.CommentResolver implementation.
+func (r *Resolver) Comment() generated.CommentResolver { return &commentResolver{r} }
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
+// Post returns generated.PostResolver implementation.
+func (r *Resolver) Post() generated.PostResolver { return &postResolver{r} }
+
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
 // Subscription returns generated.SubscriptionResolver implementation.
 func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
 
+// User returns generated.UserResolver implementation.
+func (r *Resolver) User() generated.UserResolver { return &userResolver{r} }
+
+// Viewer returns generated.ViewerResolver implementation.
+func (r *Resolver) Viewer() generated.ViewerResolver { return &viewerResolver{r} }
+
+type commentResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
+type postResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
+type userResolver struct{ *Resolver }
+type viewerResolver struct{ *Resolver }
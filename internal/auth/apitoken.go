@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
+)
+
+// Права личных токенов.
+const (
+	ScopePostsWrite     = "posts:write"
+	ScopeCommentsWrite  = "comments:write"
+	ScopeReactionsWrite = "reactions:write"
+	ScopeModerate       = "moderate"
+)
+
+// Scopes — все права, которые можно выдать токену.
+var Scopes = []string{ScopePostsWrite, ScopeCommentsWrite, ScopeReactionsWrite, ScopeModerate}
+
+// apiTokenPrefix отличает личный токен от JWT в том же заголовке Authorization.
+const apiTokenPrefix = "pat_"
+
+// NewAPIToken создаёт случайный личный токен и возвращает его вместе с хэшем для хранения.
+func NewAPIToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken — хэш, под которым токен лежит в хранилище. Токен случайный и длинный, поэтому соль не нужна.
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// APITokens проверяет личные токены из заголовка Authorization: Bearer pat_...
+func APITokens(st store.Store) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		token, ok := bearerToken(r)
+		if !ok || !strings.HasPrefix(token, apiTokenPrefix) {
+			return nil, nil
+		}
+
+		t, err := st.GetAPITokenByHash(r.Context(), HashAPIToken(token))
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("%w: unknown api token", ErrUnauthorized)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !t.Active(time.Now()) {
+			return nil, fmt.Errorf("%w: api token is revoked or expired", ErrUnauthorized)
+		}
+		return &Principal{Name: t.User, TokenID: t.ID, Scopes: t.Scopes}, nil
+	})
+}
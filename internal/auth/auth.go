@@ -0,0 +1,143 @@
+// Package auth определяет, от чьего имени выполняется запрос: Authenticator проверяет учётные данные HTTP-запроса,
+// а Middleware кладёт найденного пользователя в контекст.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ErrUnauthorized — учётные данные в запросе есть, но они неверны или просрочены.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Principal — проверенный пользователь запроса.
+type Principal struct {
+	// Name — имя, под которым пользователь пишет посты и комментарии.
+	Name string
+	// TokenID — id личного токена, которым вошёл пользователь; пусто для других способов входа.
+	TokenID string
+	// Scopes — права личного токена. Остальные способы входа ограничений не имеют.
+	Scopes []string
+}
+
+// Allows сообщает, разрешено ли пользователю действие с правом scope.
+func (p *Principal) Allows(scope string) bool {
+	return p.TokenID == "" || slices.Contains(p.Scopes, scope)
+}
+
+type key struct{}
+
+var k key
+
+func Into(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, k, p)
+}
+
+// From возвращает пользователя запроса или nil для анонимного запроса.
+func From(ctx context.Context) *Principal {
+	if p, ok := ctx.Value(k).(*Principal); ok {
+		return p
+	}
+	return nil
+}
+
+// Authenticator проверяет учётные данные запроса. Если своих учётных данных в запросе нет, возвращает nil, nil.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// Header верит имени пользователя из заголовка name. Годится только за прокси, который сам проверяет
+// пользователя и перезаписывает заголовок.
+func Header(name string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		user := strings.TrimSpace(r.Header.Get(name))
+		if user == "" {
+			return nil, nil
+		}
+		return &Principal{Name: user}, nil
+	})
+}
+
+// Chain пробует аутентификаторы по очереди и возвращает первого найденного пользователя или первую ошибку.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (*Principal, error) {
+		for _, a := range authenticators {
+			p, err := a.Authenticate(r)
+			if err != nil || p != nil {
+				return p, err
+			}
+		}
+		return nil, nil
+	})
+}
+
+// Middleware кладёт пользователя запроса в контекст. Запрос без учётных данных проходит дальше анонимным,
+// с неверными — получает 401. Без аутентификатора все запросы анонимные.
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if a == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.Authenticate(r)
+			if err != nil {
+				writeAuthError(w, err)
+				return
+			}
+			if p != nil {
+				r = r.WithContext(Into(r.Context(), p))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WithAuthorization проверяет значение Authorization, пришедшее не заголовком HTTP (например, в connection_init
+// websocket), и кладёт найденного пользователя в ctx.
+func WithAuthorization(ctx context.Context, a Authenticator, authorization string) (context.Context, error) {
+	if a == nil || authorization == "" {
+		return ctx, nil
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return ctx, err
+	}
+	r.Header.Set("Authorization", authorization)
+
+	p, err := a.Authenticate(r)
+	if err != nil {
+		return ctx, err
+	}
+	if p != nil {
+		ctx = Into(ctx, p)
+	}
+	return ctx, nil
+}
+
+// writeAuthError отвечает ошибкой в формате GraphQL, чтобы клиент разобрал её так же, как ошибки резолверов.
+// Ошибки, не связанные с учётными данными (например, недоступно хранилище токенов), наружу не раскрываются.
+func writeAuthError(w http.ResponseWriter, err error) {
+	status, code, msg := http.StatusUnauthorized, "UNAUTHENTICATED", err.Error()
+	if !errors.Is(err, ErrUnauthorized) {
+		status, code, msg = http.StatusInternalServerError, "INTERNAL", "authentication failed"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]any{{
+			"message":    msg,
+			"extensions": map[string]any{"code": code},
+		}},
+	})
+}
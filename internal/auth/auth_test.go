@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
+)
+
+func TestMiddleware(t *testing.T) {
+	failing := auth.AuthenticatorFunc(func(r *http.Request) (*auth.Principal, error) {
+		if r.Header.Get("Authorization") == "" {
+			return nil, nil
+		}
+		return nil, auth.ErrUnauthorized
+	})
+
+	var got *auth.Principal
+	h := auth.Middleware(auth.Chain(failing, auth.Header("X-User")))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = auth.From(r.Context())
+	}))
+
+	cases := []struct {
+		name   string
+		header map[string]string
+		status int
+		user   string
+	}{
+		{name: "anonymous", status: http.StatusOK},
+		{name: "header", header: map[string]string{"X-User": "alice"}, status: http.StatusOK, user: "alice"},
+		// первый аутентификатор нашёл свои учётные данные и отверг их: до заголовка дело не доходит
+		{name: "invalid credentials", header: map[string]string{"Authorization": "x", "X-User": "alice"}, status: http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, "/query", nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Fatalf("status %d, want %d", rec.Code, tc.status)
+			}
+			switch {
+			case tc.user == "" && got != nil:
+				t.Fatalf("expected anonymous request, got %+v", got)
+			case tc.user != "" && (got == nil || got.Name != tc.user):
+				t.Fatalf("expected %s, got %+v", tc.user, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWK — ключ проверки подписи токена: секрет HS256 или открытый ключ RS256.
+type JWK struct {
+	// ID — kid ключа; токен с kid проверяется только ключами с тем же ID.
+	ID     string
+	Secret []byte
+	Public *rsa.PublicKey
+}
+
+func (k JWK) alg() string {
+	if k.Public != nil {
+		return "RS256"
+	}
+	return "HS256"
+}
+
+// JWTConfig — параметры проверки подписанных токенов.
+type JWTConfig struct {
+	Keys []JWK
+	// Issuer и Audience, если заданы, должны совпадать с iss и одним из aud токена.
+	Issuer   string
+	Audience string
+	// NameClaim — claim с именем пользователя; по умолчанию sub.
+	NameClaim string
+	// Leeway — допустимое расхождение часов при проверке exp и nbf.
+	Leeway time.Duration
+}
+
+// JWT проверяет bearer-токены JWT локально, по ключам из конфигурации, без обращения к серверу авторизации.
+type JWT struct {
+	cfg JWTConfig
+	now func() time.Time
+}
+
+func NewJWT(cfg JWTConfig) (*JWT, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("jwt: no verification keys")
+	}
+	for _, k := range cfg.Keys {
+		if k.Public == nil && len(k.Secret) == 0 {
+			return nil, fmt.Errorf("jwt: key %q has neither secret nor public key", k.ID)
+		}
+	}
+	if cfg.NameClaim == "" {
+		cfg.NameClaim = "sub"
+	}
+	return &JWT{cfg: cfg, now: time.Now}, nil
+}
+
+// Authenticate проверяет токен из заголовка Authorization: Bearer. Запрос без bearer-токена в формате JWT
+// оставляет другим аутентификаторам.
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+	return j.Verify(token)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify проверяет подпись и сроки токена и возвращает пользователя из его claims.
+func (j *JWT) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthorized)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrUnauthorized)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token signature", ErrUnauthorized)
+	}
+	if !j.verifySignature(header, parts[0]+"."+parts[1], sig) {
+		return nil, fmt.Errorf("%w: invalid token signature", ErrUnauthorized)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed token claims", ErrUnauthorized)
+	}
+	if err := j.checkClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	}
+
+	name, _ := claims[j.cfg.NameClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: token has no %s claim", ErrUnauthorized, j.cfg.NameClaim)
+	}
+	return &Principal{Name: name}, nil
+}
+
+// verifySignature ищет ключ с тем же алгоритмом (и kid, если он задан), которым подпись сходится.
+// Алгоритм ключа фиксирован, поэтому токен HS256 не пройдёт проверку открытым ключом RS256 как секретом.
+func (j *JWT) verifySignature(header jwtHeader, signed string, sig []byte) bool {
+	if header.Alg != "HS256" && header.Alg != "RS256" {
+		return false
+	}
+	digest := sha256.Sum256([]byte(signed))
+	for _, k := range j.cfg.Keys {
+		if k.alg() != header.Alg || (header.Kid != "" && k.ID != header.Kid) {
+			continue
+		}
+		if k.Public != nil {
+			if rsa.VerifyPKCS1v15(k.Public, crypto.SHA256, digest[:], sig) == nil {
+				return true
+			}
+			continue
+		}
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write([]byte(signed))
+		if hmac.Equal(mac.Sum(nil), sig) {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *JWT) checkClaims(claims map[string]any) error {
+	now := j.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("token has no exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(j.cfg.Leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+
+	if j.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.cfg.Issuer {
+			return errors.New("invalid token issuer")
+		}
+	}
+	if j.cfg.Audience != "" && !slices.Contains(audiences(claims["aud"]), j.cfg.Audience) {
+		return errors.New("invalid token audience")
+	}
+	return nil
+}
+
+// audiences разбирает aud: по спецификации это строка или массив строк.
+func audiences(v any) []string {
+	switch aud := v.(type) {
+	case string:
+		return []string{aud}
+	case []any:
+		out := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ParseRSAPublicKey разбирает открытый ключ RSA в PEM (PKIX или PKCS#1).
+func ParseRSAPublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return pub, nil
+}
+
+// LoadJWKS читает ключи проверки из локального файла JWKS. Берутся ключи RSA (RS256) и oct (HS256) для подписи;
+// остальные пропускаются.
+func LoadJWKS(path string) ([]JWK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+
+	var keys []JWK
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			n, err := base64.RawURLEncoding.DecodeString(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key %q: invalid n: %w", k.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key %q: invalid e: %w", k.Kid, err)
+			}
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys = append(keys, JWK{ID: k.Kid, Public: pub})
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("jwks key %q: invalid k: %w", k.Kid, err)
+			}
+			keys = append(keys, JWK{ID: k.Kid, Secret: secret})
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s: no RS256 or HS256 signing keys", path)
+	}
+	return keys, nil
+}
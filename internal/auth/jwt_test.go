@@ -0,0 +1,140 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/auth"
+)
+
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT_Verify(t *testing.T) {
+	secret := []byte("s3cret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	j, err := auth.NewJWT(auth.JWTConfig{
+		Keys:     []auth.JWK{{Secret: secret}, {ID: "rs", Public: &rsaKey.PublicKey}},
+		Issuer:   "issuer",
+		Audience: "posts",
+	})
+	if err != nil {
+		t.Fatalf("new jwt: %v", err)
+	}
+
+	now := time.Now().Unix()
+	claims := func(over map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "issuer", "aud": []string{"other", "posts"}, "exp": now + 60}
+		for k, v := range over {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256", signJWT(t, "HS256", "", secret, claims(nil)), true},
+		{"rs256", signJWT(t, "RS256", "rs", rsaKey, claims(map[string]any{"aud": "posts"})), true},
+		{"wrong secret", signJWT(t, "HS256", "", []byte("other"), claims(nil)), false},
+		{"unknown kid", signJWT(t, "RS256", "nope", rsaKey, claims(nil)), false},
+		{"expired", signJWT(t, "HS256", "", secret, claims(map[string]any{"exp": now - 60})), false},
+		{"no exp", signJWT(t, "HS256", "", secret, claims(map[string]any{"exp": nil})), false},
+		{"not yet valid", signJWT(t, "HS256", "", secret, claims(map[string]any{"nbf": now + 600})), false},
+		{"wrong issuer", signJWT(t, "HS256", "", secret, claims(map[string]any{"iss": "evil"})), false},
+		{"wrong audience", signJWT(t, "HS256", "", secret, claims(map[string]any{"aud": "other"})), false},
+		{"alg none", signJWT(t, "none", "", []byte{}, claims(nil)), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := j.Verify(tc.token)
+			if tc.ok {
+				if err != nil || p.Name != "alice" {
+					t.Fatalf("expected alice, got %+v %v", p, err)
+				}
+				return
+			}
+			if !errors.Is(err, auth.ErrUnauthorized) {
+				t.Fatalf("expected ErrUnauthorized, got %+v %v", p, err)
+			}
+		})
+	}
+
+	// bearer-токен проверяется и в заголовке HTTP, и в connection_init
+	req := httptest.NewRequest("POST", "/query", nil)
+	req.Header.Set("Authorization", "Bearer "+cases[0].token)
+	if p, err := j.Authenticate(req); err != nil || p == nil || p.Name != "alice" {
+		t.Fatalf("authenticate: %+v %v", p, err)
+	}
+	ctx, err := auth.WithAuthorization(req.Context(), j, "Bearer "+cases[2].token)
+	if err == nil || auth.From(ctx) != nil {
+		t.Fatalf("expected rejected connection_init, got %v", err)
+	}
+}
+
+func TestLoadJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "k1", "use": "sig", "n": enc(rsaKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "EC", "kid": "ec"},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := auth.LoadJWKS(path)
+	if err != nil || len(keys) != 1 || keys[0].ID != "k1" {
+		t.Fatalf("expected only signing RSA key, got %+v %v", keys, err)
+	}
+	j, _ := auth.NewJWT(auth.JWTConfig{Keys: keys})
+	token := signJWT(t, "RS256", "k1", rsaKey, map[string]any{"sub": "bob", "exp": time.Now().Add(time.Minute).Unix()})
+	if p, err := j.Verify(token); err != nil || p.Name != "bob" {
+		t.Fatalf("verify with jwks key: %+v %v", p, err)
+	}
+}
@@ -12,7 +12,6 @@ import (
 
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph"
-	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/generated"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/logger"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/pubsub"
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/store"
@@ -29,7 +28,7 @@ func startTestServer(t *testing.T) (*http.Server, string, func()) {
 	bus := pubsub.NewMemoryBus()
 
 	resolvers := &graph.Resolver{Store: st, Bus: bus, Logger: logger.Log}
-	server := handler.New(generated.NewExecutableSchema(generated.Config{Resolvers: resolvers}))
+	server := handler.New(graph.NewExecutableSchema(resolvers))
 	server.AddTransport(transport.POST{})
 	server.Use(extension.Introspection{})
 
@@ -0,0 +1,289 @@
+// Package migrate применяет встроенные SQL-миграции к PostgreSQL.
+//
+// Версия схемы хранится в таблице schema_migrations в том же формате, что у golang-migrate,
+// поэтому базы, которые раньше мигрировал контейнер migrate/migrate, подхватываются как есть.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var (
+	ErrDirty        = errors.New("schema is dirty: a migration failed halfway, fix it by hand and run migrate force N")
+	ErrNoVersion    = errors.New("unknown migration version")
+	fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+// lockKey — ключ advisory-блокировки, чтобы два процесса не мигрировали одновременно.
+const lockKey = 0x6d696772617465
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load читает пары NNNN_name.up.sql / NNNN_name.down.sql из корня fsys, упорядоченные по версии.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		m := fileNamePattern.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", mig.Version, mig.Name)
+		}
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+type Runner struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB, migrations []Migration) *Runner {
+	return &Runner{db: db, migrations: migrations}
+}
+
+// Latest — версия последней встроенной миграции, то есть схема, которую ожидает бинарник.
+func (r *Runner) Latest() int {
+	if len(r.migrations) == 0 {
+		return 0
+	}
+	return r.migrations[len(r.migrations)-1].Version
+}
+
+type Status struct {
+	Version int // 0 — миграции ещё не применялись
+	Dirty   bool
+	Latest  int
+	Pending []Migration
+}
+
+func (r *Runner) Status(ctx context.Context) (*Status, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &Status{Version: version, Dirty: dirty, Latest: r.Latest()}
+	for _, m := range r.migrations {
+		if m.Version > version {
+			st.Pending = append(st.Pending, m)
+		}
+	}
+	return st, nil
+}
+
+// Up применяет все ещё не применённые миграции.
+func (r *Runner) Up(ctx context.Context) error {
+	return r.To(ctx, r.Latest())
+}
+
+// Down откатывает одну последнюю применённую миграцию.
+func (r *Runner) Down(ctx context.Context) error {
+	return r.locked(ctx, func(conn *sql.Conn, version int) error {
+		if version == 0 {
+			return nil
+		}
+		i := r.index(version)
+		if i < 0 {
+			return fmt.Errorf("%w: %d", ErrNoVersion, version)
+		}
+		prev := 0
+		if i > 0 {
+			prev = r.migrations[i-1].Version
+		}
+		return step(ctx, conn, r.migrations[i].Down, prev)
+	})
+}
+
+// To поднимает или опускает схему до версии target; 0 откатывает всё.
+func (r *Runner) To(ctx context.Context, target int) error {
+	if target != 0 && r.index(target) < 0 {
+		return fmt.Errorf("%w: %d", ErrNoVersion, target)
+	}
+
+	return r.locked(ctx, func(conn *sql.Conn, version int) error {
+		if version != 0 && r.index(version) < 0 {
+			return fmt.Errorf("%w: database is at %d", ErrNoVersion, version)
+		}
+
+		for _, m := range r.migrations {
+			if m.Version > version && m.Version <= target {
+				if err := step(ctx, conn, m.Up, m.Version); err != nil {
+					return fmt.Errorf("migration %04d_%s up: %w", m.Version, m.Name, err)
+				}
+			}
+		}
+		for i := len(r.migrations) - 1; i >= 0; i-- {
+			m := r.migrations[i]
+			if m.Version <= version && m.Version > target {
+				prev := 0
+				if i > 0 {
+					prev = r.migrations[i-1].Version
+				}
+				if err := step(ctx, conn, m.Down, prev); err != nil {
+					return fmt.Errorf("migration %04d_%s down: %w", m.Version, m.Name, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Force записывает версию без выполнения SQL и снимает признак dirty — после ручного исправления схемы.
+func (r *Runner) Force(ctx context.Context, version int) error {
+	if version != 0 && r.index(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrNoVersion, version)
+	}
+
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := setVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *Runner) index(version int) int {
+	for i, m := range r.migrations {
+		if m.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// locked выполняет fn на одном соединении под advisory-блокировкой, передавая текущую версию схемы.
+func (r *Runner) locked(ctx context.Context, fn func(conn *sql.Conn, version int) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+		return err
+	}
+	defer func() { _, _ = conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockKey) }()
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	version, dirty, err := currentVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w (version %d)", ErrDirty, version)
+	}
+	return fn(conn, version)
+}
+
+// step выполняет SQL одной миграции и запись новой версии в одной транзакции: при ошибке схема не меняется.
+func step(ctx context.Context, conn *sql.Conn, query string, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if err := setVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ensureTable(ctx context.Context, conn *sql.Conn) error {
+	const q = `create table if not exists schema_migrations (version bigint not null primary key, dirty boolean not null)`
+	_, err := conn.ExecContext(ctx, q)
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (version int, dirty bool, err error) {
+	err = conn.QueryRowContext(ctx, `select version, dirty from schema_migrations limit 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+func setVersion(ctx context.Context, tx *sql.Tx, version int) error {
+	if _, err := tx.ExecContext(ctx, `delete from schema_migrations`); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `insert into schema_migrations (version, dirty) values ($1, false)`, version)
+	return err
+}
@@ -0,0 +1,44 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/internal/migrate"
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/migrations"
+)
+
+func TestLoad_PairsAndOrder(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_b.up.sql":   {Data: []byte("create table b ();")},
+		"0002_b.down.sql": {Data: []byte("drop table b;")},
+		"0001_a.up.sql":   {Data: []byte("create table a ();")},
+		"0001_a.down.sql": {Data: []byte("drop table a;")},
+		"embed.go":        {Data: []byte("package migrations")},
+	}
+
+	list, err := migrate.Load(fsys)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(list) != 2 || list[0].Version != 1 || list[1].Version != 2 || list[1].Down != "drop table b;" {
+		t.Fatalf("unexpected migrations: %+v", list)
+	}
+
+	delete(fsys, "0002_b.down.sql")
+	if _, err := migrate.Load(fsys); err == nil {
+		t.Fatal("expected migration without down file to be rejected")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	list, err := migrate.Load(migrations.FS)
+	if err != nil {
+		t.Fatalf("load embedded: %v", err)
+	}
+	for i, m := range list {
+		if m.Version != i+1 {
+			t.Fatalf("expected contiguous versions, got %04d_%s at position %d", m.Version, m.Name, i)
+		}
+	}
+}
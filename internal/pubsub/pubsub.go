@@ -10,12 +10,16 @@ type Unsubscribe func()
 
 type Bus interface {
 	Publish(topic string, msg model.Comment)
-	Subscribe(topic string, h func(model.Comment)) Unsubscribe
+	// Subscribe регистрирует обработчик темы; onClose (может быть nil) вызывается, если тему закрыли через CloseTopic.
+	Subscribe(topic string, h func(model.Comment), onClose func()) Unsubscribe
+	// CloseTopic снимает всех подписчиков темы, например когда пост удалён.
+	CloseTopic(topic string)
 }
 
 type handler struct {
-	id int64
-	fn func(model.Comment)
+	id      int64
+	fn      func(model.Comment)
+	onClose func()
 }
 
 type memoryBus struct {
@@ -53,16 +57,16 @@ func (m *memoryBus) Publish(postID string, msg model.Comment) {
 	}
 }
 
-func (m *memoryBus) Subscribe(postID string, h func(model.Comment)) Unsubscribe {
+func (m *memoryBus) Subscribe(postID string, h func(model.Comment), onClose func()) Unsubscribe {
 	m.mu.Lock()
 	if m.closed || m.m == nil {
-		m.mu.RUnlock()
+		m.mu.Unlock()
 		return func() {}
 	}
 
 	m.seq++
 	id := m.seq
-	m.m[postID] = append(m.m[postID], handler{id: id, fn: h})
+	m.m[postID] = append(m.m[postID], handler{id: id, fn: h, onClose: onClose})
 	m.mu.Unlock()
 
 	return func() {
@@ -70,7 +74,6 @@ func (m *memoryBus) Subscribe(postID string, h func(model.Comment)) Unsubscribe
 		defer m.mu.Unlock()
 
 		if m.closed || m.m == nil {
-			m.mu.RUnlock()
 			return
 		}
 
@@ -88,3 +91,21 @@ func (m *memoryBus) Subscribe(postID string, h func(model.Comment)) Unsubscribe
 		}
 	}
 }
+
+func (m *memoryBus) CloseTopic(postID string) {
+	m.mu.Lock()
+	if m.closed || m.m == nil {
+		m.mu.Unlock()
+		return
+	}
+
+	hs := m.m[postID]
+	delete(m.m, postID)
+	m.mu.Unlock()
+
+	for _, handler := range hs {
+		if handler.onClose != nil {
+			handler.onClose()
+		}
+	}
+}
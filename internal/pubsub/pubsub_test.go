@@ -28,7 +28,7 @@ func TestMemoryBus_PublishSingle(t *testing.T) {
 	postID := uuid.NewString()
 
 	got := make(chan model.Comment, 1)
-	unsub := b.Subscribe(postID, func(c model.Comment) { got <- c })
+	unsub := b.Subscribe(postID, func(c model.Comment) { got <- c }, nil)
 	defer unsub()
 
 	msg := mkComment()
@@ -58,7 +58,7 @@ func TestMemoryBus_FanOutAndUnsubscribe(t *testing.T) {
 	for i := 0; i < subs; i++ {
 		unsubs = append(unsubs, b.Subscribe(postID, func(c model.Comment) {
 			wg.Done()
-		}))
+		}, nil))
 	}
 	// опубликуем
 	b.Publish(postID, mkComment())
@@ -107,7 +107,7 @@ func TestMemoryBus_ConcurrentPublish(t *testing.T) {
 			mu.Lock()
 			got++
 			mu.Unlock()
-		})
+		}, nil)
 		unsub = append(unsub, u)
 	}
 	defer func() {
@@ -136,3 +136,29 @@ func TestMemoryBus_ConcurrentPublish(t *testing.T) {
 		t.Fatalf("expected %d messages, got %d", pubs*subs, total)
 	}
 }
+
+func TestMemoryBus_CloseTopic(t *testing.T) {
+	t.Parallel()
+	b := NewMemoryBus()
+	postID := uuid.NewString()
+
+	got := make(chan model.Comment, 1)
+	closed := make(chan struct{})
+	unsub := b.Subscribe(postID, func(c model.Comment) { got <- c }, func() { close(closed) })
+
+	b.CloseTopic(postID)
+	select {
+	case <-closed:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("onClose was not called")
+	}
+
+	// после закрытия темы сообщения не доставляются, а отписка безопасна
+	b.Publish(postID, mkComment())
+	unsub()
+	select {
+	case <-got:
+		t.Fatal("message delivered to closed topic")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+)
+
+// commentKey — ключ сортировки комментария; курсор страницы кодирует его вместе с порядком,
+// поэтому курсор одного порядка не принимается в другом.
+type commentKey struct {
+	order model.CommentOrder
+	score int       // TOP: реакции + прямые ответы
+	at    time.Time // OLDEST, NEWEST, TOP
+	path  string    // THREADED: путь от корня, см. threadPathElem
+	id    string
+}
+
+func (k commentKey) encode() string {
+	var raw string
+	if k.order == model.CommentOrderThreaded {
+		raw = string(k.order) + "|" + k.path
+	} else {
+		raw = string(k.order) + "|" + strconv.Itoa(k.score) + "|" + k.at.Format(time.RFC3339Nano) + "|" + k.id
+	}
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCommentKey(cursor string, order model.CommentOrder) (commentKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return commentKey{}, ErrInvalidCursor
+	}
+
+	parts := strings.Split(string(decoded), "|")
+	if len(parts) < 2 || model.CommentOrder(parts[0]) != order {
+		return commentKey{}, ErrInvalidCursor
+	}
+
+	if order == model.CommentOrderThreaded {
+		path := parts[1]
+		return commentKey{order: order, path: path, id: path[strings.LastIndex(path, "/")+1:]}, nil
+	}
+
+	if len(parts) != 4 {
+		return commentKey{}, ErrInvalidCursor
+	}
+	score, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return commentKey{}, ErrInvalidCursor
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[2])
+	if err != nil {
+		return commentKey{}, ErrInvalidCursor
+	}
+	return commentKey{order: order, score: score, at: at, id: parts[3]}, nil
+}
+
+// before сообщает, идёт ли k раньше other в выдаче порядка k.order.
+func (k commentKey) before(other commentKey) bool {
+	switch k.order {
+	case model.CommentOrderNewest:
+		if !k.at.Equal(other.at) {
+			return k.at.After(other.at)
+		}
+		return k.id > other.id
+	case model.CommentOrderTop:
+		if k.score != other.score {
+			return k.score > other.score
+		}
+	case model.CommentOrderThreaded:
+		return k.path < other.path
+	}
+	if !k.at.Equal(other.at) {
+		return k.at.Before(other.at)
+	}
+	return k.id < other.id
+}
+
+// threadPathElem — элемент пути в THREADED-порядке: время в микросекундах фиксированной ширины и id.
+// Пути сравниваются как строки, поэтому ответ идёт сразу за родителем, а ветки — по времени создания.
+func threadPathElem(c *model.Comment) string {
+	return fmt.Sprintf("%020d", c.CreatedAt.UnixMicro()) + c.ID
+}
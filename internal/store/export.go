@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+)
+
+type RecordType string
+
+const (
+	RecordPost    RecordType = "post"
+	RecordComment RecordType = "comment"
+)
+
+// Record — строка NDJSON-выгрузки: пост со своими тегами или комментарий.
+// У удалённого комментария, как и в API, текст и автор пустые, но время удаления deletedAt сохраняется.
+type Record struct {
+	Type    RecordType     `json:"type"`
+	Post    *model.Post    `json:"post,omitempty"`
+	Tags    []string       `json:"tags,omitempty"`
+	Comment *model.Comment `json:"comment,omitempty"`
+}
+
+// importBatch делит записи на посты и комментарии, сохраняя их порядок внутри каждой группы.
+func importBatch(records []Record) (posts []Record, comments []*model.Comment, err error) {
+	for _, rec := range records {
+		switch {
+		case rec.Type == RecordPost && rec.Post != nil:
+			posts = append(posts, rec)
+		case rec.Type == RecordComment && rec.Comment != nil:
+			comments = append(comments, rec.Comment)
+		default:
+			return nil, nil, fmt.Errorf("invalid record of type %q", rec.Type)
+		}
+	}
+	return posts, comments, nil
+}
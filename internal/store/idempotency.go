@@ -0,0 +1,21 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// IdempotencyKey — ключ идемпотентности мутации: повтор с тем же ключом от того же пользователя получает
+// сохранённый Result, а не выполняет мутацию ещё раз.
+type IdempotencyKey struct {
+	User      string          `json:"user"`
+	Key       string          `json:"key"`
+	Op        string          `json:"op"` // мутация, для которой ключ использован впервые
+	Result    json.RawMessage `json:"result,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// idempotencyID — ключ карты MemStore: ключи разных пользователей не пересекаются.
+func idempotencyID(user, key string) string {
+	return user + "\x00" + key
+}
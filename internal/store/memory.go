@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
-	"encoding/base64"
+	"fmt"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,24 +13,156 @@ import (
 )
 
 type MemStore struct {
-	mu       sync.RWMutex
+	// общий RWMutex; у хранилища внутри WithTx — пустышка, блокировку уже держит WithTx
+	mu rwLocker
+	*memState
+}
+
+// memState — данные MemStore, общие для хранилища и его представления внутри WithTx.
+type memState struct {
 	Posts    map[string]*model.Post
 	Comments map[string]*model.Comment
+	// прежние версии текста комментария, от старых к новым
+	Revisions map[string][]*model.CommentRevision
+	// реакции: цель (пост или комментарий) -> вид -> пользователь
+	Reactions map[string]map[model.ReactionKind]map[string]struct{}
+	// теги поста по алфавиту
+	Tags map[string][]string
+	// ключи идемпотентности по idempotencyID
+	IdempotencyKeys map[string]*IdempotencyKey
+	Users           map[string]*model.User
+	APITokens       map[string]*model.APIToken
+
+	// посты в порядке (feedTime desc, id desc) для keyset-пагинации
+	postsByTime []*model.Post
+	// индексы веток в порядке (created_at, id): корневые комментарии поста и ответы на комментарий
+	roots   map[string][]*model.Comment
+	replies map[string][]*model.Comment
+	index   *searchIndex
+	// журнал изменений; nil, если MemStore открыт без каталога данных
+	wal *walLog
+	// откат текущей WithTx; nil вне транзакции
+	undo *memUndo
 }
 
 func NewMemStore() Store {
 	return &MemStore{
-		Posts:    map[string]*model.Post{},
-		Comments: map[string]*model.Comment{},
+		mu: &sync.RWMutex{},
+		memState: &memState{
+			Posts:           map[string]*model.Post{},
+			Comments:        map[string]*model.Comment{},
+			Revisions:       map[string][]*model.CommentRevision{},
+			Reactions:       map[string]map[model.ReactionKind]map[string]struct{}{},
+			Tags:            map[string][]string{},
+			roots:           map[string][]*model.Comment{},
+			IdempotencyKeys: map[string]*IdempotencyKey{},
+			Users:           map[string]*model.User{},
+			APITokens:       map[string]*model.APIToken{},
+			replies:         map[string][]*model.Comment{},
+			index:           newSearchIndex(),
+		},
 	}
 }
 
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+// WithTx выполняет fn под одной блокировкой записи: между проверками и записью внутри fn никто не вклинится.
+// Если fn вернула ошибку, её изменения откатываются и не попадают в журнал.
+// Внутри fn нужно работать только с tx: вызов исходного хранилища заблокируется навсегда.
+func (m *MemStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if _, inTx := m.mu.(noLock); inTx {
+		return fn(m)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.undo = newMemUndo()
+	if err := fn(&MemStore{mu: noLock{}, memState: m.memState}); err != nil {
+		m.rollback()
+		return err
+	}
+
+	// журнал пишется до снятия отката: если запись не удалась, транзакция откатывается и в памяти
+	if m.wal == nil || len(m.undo.wal) == 0 {
+		m.undo = nil
+		return nil
+	}
+	if err := m.appendWAL(m.undo.wal); err != nil {
+		m.rollback()
+		return err
+	}
+	m.undo = nil
+	return m.maybeCompact()
+}
+
 func (m *MemStore) CreatePost(ctx context.Context, post *model.Post) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	defaultPostStatus(post)
+	if post.Version == 0 {
+		post.Version = 1
+	}
+	m.savePost(post.ID)
+
+	if _, exists := m.Posts[post.ID]; !exists {
+		m.insertInFeed(post)
+	}
+
 	m.Posts[post.ID] = post
-	return nil
+	m.index.indexPost(post)
+	m.ensureUser(post.Author, post.CreatedAt)
+	return m.log(walRecord{Op: opCreatePost, Post: post})
+}
+
+// ensureUser заводит пользователя name, если его ещё нет. Отдельно в журнал не пишется: при проигрывании
+// его снова заведут записи постов и комментариев, а id вычисляется из имени. Вызывается под m.mu.
+func (m *MemStore) ensureUser(name string, at time.Time) {
+	if name == "" {
+		return
+	}
+	id := UserID(name)
+	if _, ok := m.Users[id]; !ok {
+		m.saveUser(id)
+		m.Users[id] = &model.User{ID: id, Name: name, Role: model.RoleUser, CreatedAt: at}
+	}
+}
+
+// postBefore сообщает, идёт ли a раньше b в ленте: сначала новые по feedTime, при равном времени — больший id.
+func postBefore(a, b *model.Post) bool {
+	at, bt := feedTime(a), feedTime(b)
+	if at.Equal(bt) {
+		return a.ID > b.ID
+	}
+	return at.After(bt)
+}
+
+// insertInFeed и removeFromFeed поддерживают порядок postsByTime; пост, у которого меняется feedTime,
+// убирают из ленты до правки и возвращают после. Вызываются под m.mu.
+func (s *memState) insertInFeed(post *model.Post) {
+	i := sort.Search(len(s.postsByTime), func(i int) bool { return postBefore(post, s.postsByTime[i]) })
+	s.postsByTime = append(s.postsByTime, nil)
+	copy(s.postsByTime[i+1:], s.postsByTime[i:])
+	s.postsByTime[i] = post
+}
+
+func (s *memState) removeFromFeed(post *model.Post) {
+	i := sort.Search(len(s.postsByTime), func(i int) bool { return !postBefore(s.postsByTime[i], post) })
+	if i < len(s.postsByTime) && s.postsByTime[i].ID == post.ID {
+		s.postsByTime = append(s.postsByTime[:i], s.postsByTime[i+1:]...)
+	}
 }
 
 func (m *MemStore) GetPost(ctx context.Context, id string) (*model.Post, error) {
@@ -40,42 +174,79 @@ func (m *MemStore) GetPost(ctx context.Context, id string) (*model.Post, error)
 		return nil, ErrNotFound
 	}
 
-	count := 0
-	for _, comment := range m.Comments {
-		if comment.PostID == post.ID {
-			count++
+	return post, nil
+}
+
+func (m *MemStore) GetPosts(ctx context.Context, ids []string) (map[string]*model.Post, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]*model.Post, len(ids))
+	for _, id := range ids {
+		if p, ok := m.Posts[id]; ok {
+			out[id] = p
 		}
 	}
-	post.CommentsCount = count
-
-	return post, nil
+	return out, nil
 }
 
-func (m *MemStore) ListPosts(ctx context.Context) ([]*model.Post, error) {
+func (m *MemStore) ListPosts(ctx context.Context, filter PostFilter, after *string, limit int) (*model.PostPage, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	posts := make([]*model.Post, 0, len(m.Posts))
-	for _, p := range m.Posts {
-		posts = append(posts, p)
+	start := 0
+	if after != nil && *after != "" {
+		ts, id, ok := decodeCursor(*after)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		// первый пост строго после курсора в порядке (feedTime desc, id desc)
+		start = sort.Search(len(m.postsByTime), func(i int) bool {
+			t := feedTime(m.postsByTime[i])
+			return t.Before(ts) || (t.Equal(ts) && m.postsByTime[i].ID < id)
+		})
 	}
 
-	sort.Slice(posts, func(i, j int) bool { return posts[i].CreatedAt.After(posts[j].CreatedAt) })
+	page := make([]*model.Post, 0, limit)
+	hasNext := false
+	for _, p := range m.postsByTime[start:] {
+		if filter.Since != nil && feedTime(p).Before(*filter.Since) {
+			// дальше только более старые посты
+			break
+		}
+		if !m.matchPost(filter, p) {
+			continue
+		}
+		if len(page) == limit {
+			hasNext = true
+			break
+		}
+		page = append(page, p)
+	}
 
-	for _, p := range posts {
-		count := 0
-		for _, comment := range m.Comments {
-			if comment.PostID == p.ID {
-				count++
-			}
+	edges := make([]*model.PostEdge, 0, len(page))
+	for _, p := range page {
+		edges = append(edges, &model.PostEdge{Cursor: encodeCursor(feedTime(p), p.ID), Node: p})
+	}
+
+	hasPrev := false
+	for _, p := range m.postsByTime[:start] {
+		if m.matchPost(filter, p) {
+			hasPrev = true
+			break
 		}
-		p.CommentsCount = count
 	}
 
-	return posts, nil
+	pageInfo := &model.PageInfo{HasNextPage: hasNext, HasPreviousPage: hasPrev}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
+		pageInfo.EndCursor = &edges[len(edges)-1].Cursor
+	}
+
+	return &model.PostPage{Edges: edges, PageInfo: pageInfo}, nil
 }
 
-func (m *MemStore) CloseComments(ctx context.Context, id string, closed bool) (*model.Post, error) {
+func (m *MemStore) CloseComments(ctx context.Context, id string, closed bool, expectedVersion *int) (*model.Post, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -83,11 +254,198 @@ func (m *MemStore) CloseComments(ctx context.Context, id string, closed bool) (*
 	if !ok {
 		return nil, ErrNotFound
 	}
+	if err := checkVersion(expectedVersion, post.Version); err != nil {
+		return nil, err
+	}
 
+	m.savePost(id)
 	post.CommentsClosed = closed
+	post.Version++
+	if err := m.log(walRecord{Op: opCloseComments, ID: id, Closed: closed}); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (m *MemStore) UpdatePost(ctx context.Context, id string, title *string, body *string, expectedVersion *int) (*model.Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.Posts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(expectedVersion, post.Version); err != nil {
+		return nil, err
+	}
+
+	m.savePost(id)
+	post.Version++
+	if title != nil {
+		post.Title = *title
+	}
+	if body != nil {
+		post.Body = *body
+	}
+	m.index.indexPost(post)
+	if err := m.log(walRecord{Op: opUpdatePost, ID: id, Title: title, Body: body}); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+func (m *MemStore) DeletePost(ctx context.Context, id string, expectedVersion *int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.Posts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(expectedVersion, post.Version); err != nil {
+		return err
+	}
+
+	m.savePost(id)
+	m.saveReactions(id)
+	m.saveTags(id)
+	for cid, comment := range m.Comments {
+		if comment.PostID == id {
+			m.saveComment(cid)
+			delete(m.Comments, cid)
+			delete(m.Revisions, cid)
+			delete(m.Reactions, cid)
+			delete(m.replies, cid)
+			m.index.removeComment(cid)
+		}
+	}
+
+	m.removeFromFeed(post)
+	delete(m.Posts, id)
+	delete(m.roots, id)
+	delete(m.Reactions, id)
+	delete(m.Tags, id)
+	m.index.removePost(id)
+	return m.log(walRecord{Op: opDeletePost, ID: id})
+}
+
+func (m *MemStore) SetPostStatus(ctx context.Context, id string, status model.PostStatus, publishAt *time.Time, expectedVersion *int) (*model.Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.Posts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(expectedVersion, post.Version); err != nil {
+		return nil, err
+	}
+
+	m.savePost(id)
+	m.removeFromFeed(post)
+	post.Version++
+	post.Status = status
+	post.PublishAt = publishAt
+	m.insertInFeed(post)
+	m.index.indexPost(post)
+	if err := m.log(walRecord{Op: opSetPostStatus, ID: id, Status: status, At: publishAt}); err != nil {
+		return nil, err
+	}
 	return post, nil
 }
 
+func (m *MemStore) PublishDue(ctx context.Context, now time.Time) ([]*model.Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []*model.Post
+	for _, post := range m.Posts {
+		if post.Status != model.PostStatusScheduled || post.PublishAt.After(now) {
+			continue
+		}
+		m.savePost(post.ID)
+		post.Status = model.PostStatusPublished
+		post.Version++
+		m.index.indexPost(post)
+		out = append(out, post)
+	}
+	if len(out) > 0 {
+		if err := m.log(walRecord{Op: opPublishDue, At: &now}); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// matchPost дополняет PostFilter.match фильтром по тегу, который хранится отдельно от поста.
+func (m *MemStore) matchPost(filter PostFilter, p *model.Post) bool {
+	if filter.Tag != nil && !slices.Contains(m.Tags[p.ID], *filter.Tag) {
+		return false
+	}
+	return filter.match(p)
+}
+
+func (m *MemStore) SetPostTags(ctx context.Context, postID string, tags []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.Posts[postID]; !ok {
+		return ErrNotFound
+	}
+
+	m.saveTags(postID)
+	if len(tags) == 0 {
+		delete(m.Tags, postID)
+	} else {
+		sorted := slices.Clone(tags)
+		slices.Sort(sorted)
+		m.Tags[postID] = sorted
+	}
+	return m.log(walRecord{Op: opSetPostTags, ID: postID, Tags: tags})
+}
+
+func (m *MemStore) BatchPostTags(ctx context.Context, postIDs []string) (map[string][]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string][]string, len(postIDs))
+	for _, id := range postIDs {
+		out[id] = slices.Clone(m.Tags[id])
+	}
+	return out, nil
+}
+
+func (m *MemStore) ListTags(ctx context.Context, limit int) ([]*model.Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// черновики и отложенные посты не считаются, как и в выдаче posts(tag:)
+	counts := map[string]int{}
+	for postID, tags := range m.Tags {
+		if p := m.Posts[postID]; p == nil || p.Status != model.PostStatusPublished {
+			continue
+		}
+		for _, tag := range tags {
+			counts[tag]++
+		}
+	}
+
+	out := make([]*model.Tag, 0, len(counts))
+	for name, n := range counts {
+		out = append(out, &model.Tag{Name: name, PostsCount: n})
+	}
+	slices.SortFunc(out, func(a, b *model.Tag) int {
+		if a.PostsCount != b.PostsCount {
+			return b.PostsCount - a.PostsCount
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
 func (m *MemStore) CreateComment(ctx context.Context, comment *model.Comment) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -95,6 +453,9 @@ func (m *MemStore) CreateComment(ctx context.Context, comment *model.Comment) er
 	if comment.ParentID != nil && *comment.ParentID == "" {
 		comment.ParentID = nil
 	}
+	if comment.Version == 0 {
+		comment.Version = 1
+	}
 
 	if comment.ParentID != nil {
 		if parent := m.Comments[*comment.ParentID]; parent != nil {
@@ -102,8 +463,39 @@ func (m *MemStore) CreateComment(ctx context.Context, comment *model.Comment) er
 		}
 	}
 
+	m.saveComment(comment.ID)
+	if _, exists := m.Comments[comment.ID]; !exists {
+		if post := m.Posts[comment.PostID]; post != nil && !comment.Deleted {
+			m.savePost(post.ID)
+			post.CommentsCount++
+		}
+		if comment.ParentID == nil {
+			m.roots[comment.PostID] = insertByTime(m.roots[comment.PostID], comment)
+		} else {
+			m.replies[*comment.ParentID] = insertByTime(m.replies[*comment.ParentID], comment)
+		}
+	}
+
 	m.Comments[comment.ID] = comment
-	return nil
+	m.index.indexComment(comment)
+	m.ensureUser(comment.Author, comment.CreatedAt)
+	return m.log(walRecord{Op: opCreateComment, Comment: comment})
+}
+
+// insertByTime вставляет комментарий, сохраняя порядок (created_at, id).
+func insertByTime(list []*model.Comment, c *model.Comment) []*model.Comment {
+	i := sort.Search(len(list), func(i int) bool { return commentBefore(c, list[i]) })
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = c
+	return list
+}
+
+func commentBefore(a, b *model.Comment) bool {
+	if a.CreatedAt.Equal(b.CreatedAt) {
+		return a.ID < b.ID
+	}
+	return a.CreatedAt.Before(b.CreatedAt)
 }
 
 func (m *MemStore) GetComment(ctx context.Context, id string) (*model.Comment, error) {
@@ -116,72 +508,157 @@ func (m *MemStore) GetComment(ctx context.Context, id string) (*model.Comment, e
 	return c, nil
 }
 
-func (m *MemStore) ListComments(ctx context.Context, postID string, parentID *string, after *string, limit int) (*model.CommentPage, error) {
+func (m *MemStore) GetComments(ctx context.Context, ids []string) (map[string]*model.Comment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]*model.Comment, len(ids))
+	for _, id := range ids {
+		if c, ok := m.Comments[id]; ok {
+			out[id] = hideDeleted(c)
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) ListComments(ctx context.Context, q CommentsQuery) (*model.CommentPage, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var items []*model.Comment
+	keys := map[string]commentKey{}
 	for _, comment := range m.Comments {
-		if comment.PostID != postID {
+		if comment.PostID != q.PostID {
 			continue
 		}
-		// добавляем корневые комментарии
-		if parentID == nil {
-			items = append(items, comment)
-			// вложенные комментарии
-		} else {
-			if comment.ParentID != nil && *comment.ParentID == *parentID {
-				items = append(items, comment)
+
+		key := commentKey{order: q.Order, at: comment.CreatedAt, id: comment.ID}
+		switch q.Order {
+		case model.CommentOrderThreaded:
+			path, under := m.threadPath(comment, q.ParentID)
+			if !under {
+				continue
+			}
+			key.path = path
+		case model.CommentOrderTop:
+			key.score = len(m.replies[comment.ID])
+			for _, users := range m.Reactions[comment.ID] {
+				key.score += len(users)
 			}
 		}
-	}
 
-	sort.Slice(items, func(i, j int) bool {
-		if items[i].CreatedAt.Equal(items[j].CreatedAt) {
-			return items[i].ID < items[j].ID
+		// без parentID — все комментарии поста, с ним — прямые ответы
+		if q.Order != model.CommentOrderThreaded && q.ParentID != nil &&
+			(comment.ParentID == nil || *comment.ParentID != *q.ParentID) {
+			continue
 		}
-		return items[i].CreatedAt.Before(items[j].CreatedAt)
-	})
 
-	start := 0
-	if after != nil && *after != "" {
-		if decoded, err := base64.StdEncoding.DecodeString(*after); err == nil {
-			prevCursor := string(decoded)
-			for i, item := range items {
-				cursor := cursorOf(item)
-				if cursor == prevCursor {
-					start = i + 1
-					break
-				}
-			}
+		items = append(items, comment)
+		keys[comment.ID] = key
+	}
+
+	sort.Slice(items, func(i, j int) bool { return keys[items[i].ID].before(keys[items[j].ID]) })
+
+	start, end := 0, len(items)
+	if q.After != nil && *q.After != "" {
+		after, err := decodeCommentKey(*q.After, q.Order)
+		if err != nil {
+			return nil, err
 		}
+		start = sort.Search(len(items), func(i int) bool { return after.before(keys[items[i].ID]) })
+	}
+	if q.Before != nil && *q.Before != "" {
+		before, err := decodeCommentKey(*q.Before, q.Order)
+		if err != nil {
+			return nil, err
+		}
+		end = sort.Search(len(items), func(i int) bool { return !keys[items[i].ID].before(before) })
+	}
+	if end < start {
+		end = start
 	}
 
-	end := start + limit
-	if end > len(items) {
-		end = len(items)
+	if q.Backward {
+		start = max(start, end-q.Limit)
+	} else {
+		end = min(end, start+q.Limit)
 	}
 
 	page := items[start:end]
 	edges := make([]*model.CommentEdge, 0, len(page))
 	for _, comment := range page {
-		edges = append(edges, &model.CommentEdge{Cursor: encode(cursorOf(comment)), Node: comment})
+		edges = append(edges, &model.CommentEdge{Cursor: keys[comment.ID].encode(), Node: hideDeleted(comment)})
 	}
 
-	pageInfo := &model.PageInfo{HasNextPage: end < len(items)}
+	pageInfo := &model.PageInfo{HasPreviousPage: start > 0, HasNextPage: end < len(items)}
 	if len(page) > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
 		pageInfo.EndCursor = &edges[len(edges)-1].Cursor
 	}
 
-	return &model.CommentPage{Edges: edges, PageInfo: pageInfo}, nil
+	return &model.CommentPage{Edges: edges, PageInfo: pageInfo, TotalCount: len(items)}, nil
 }
 
-func cursorOf(comment *model.Comment) string {
-	return comment.CreatedAt.Format(time.RFC3339Nano) + ":" + comment.ID
+// threadPath строит путь комментария от корня ветки и сообщает, лежит ли он под parentID (nil — весь пост).
+func (m *MemStore) threadPath(c *model.Comment, parentID *string) (string, bool) {
+	elems := []string{threadPathElem(c)}
+	under := parentID == nil
+	for cur := c; cur.ParentID != nil; {
+		if parentID != nil && *cur.ParentID == *parentID {
+			under = true
+			break
+		}
+		parent, ok := m.Comments[*cur.ParentID]
+		if !ok {
+			break
+		}
+		elems = append(elems, threadPathElem(parent))
+		cur = parent
+	}
+
+	slices.Reverse(elems)
+	return strings.Join(elems, "/"), under
 }
 
-func encode(s string) string {
-	return base64.StdEncoding.EncodeToString([]byte(s))
+func (m *MemStore) CommentTree(ctx context.Context, postID string, rootID *string, maxDepth int, maxNodes int) ([]*model.CommentTreeNode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	level := m.roots[postID]
+	if rootID != nil {
+		root, ok := m.Comments[*rootID]
+		if !ok || root.PostID != postID {
+			return nil, ErrNotFound
+		}
+		level = []*model.Comment{root}
+	}
+
+	roots := make(map[string]bool, len(level))
+	for _, c := range level {
+		roots[c.ID] = true
+	}
+
+	// обход по уровням, как рекурсивный CTE в PostgresStore
+	var flat []*model.Comment
+	replies := map[string]int{}
+	for depth := 0; len(level) > 0 && len(flat) < maxNodes; depth++ {
+		if left := maxNodes - len(flat); len(level) > left {
+			level = level[:left]
+		}
+		flat = append(flat, level...)
+
+		var next []*model.Comment
+		for _, c := range level {
+			replies[c.ID] = len(m.replies[c.ID])
+			if depth < maxDepth {
+				next = append(next, m.replies[c.ID]...)
+			}
+		}
+		sort.Slice(next, func(i, j int) bool { return commentBefore(next[i], next[j]) })
+		level = next
+	}
+
+	return buildCommentTree(flat, roots, replies), nil
 }
 
 func (m *MemStore) BatchCommentsCount(ctx context.Context, postIDs []string) (map[string]int, error) {
@@ -190,13 +667,445 @@ func (m *MemStore) BatchCommentsCount(ctx context.Context, postIDs []string) (ma
 
 	out := make(map[string]int, len(postIDs))
 	for _, postID := range postIDs {
-		out[postID] = 0
+		if post := m.Posts[postID]; post != nil {
+			out[postID] = post.CommentsCount
+		} else {
+			out[postID] = 0
+		}
 	}
+	return out, nil
+}
 
-	for _, comment := range m.Comments {
-		if _, ok := out[comment.PostID]; ok {
-			out[comment.PostID]++
+func (m *MemStore) UpdateCommentBody(ctx context.Context, id string, body string, editedAt time.Time, expectedVersion *int) (*model.Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, ok := m.Comments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(expectedVersion, comment.Version); err != nil {
+		return nil, err
+	}
+
+	m.saveComment(id)
+	prevAt := comment.CreatedAt
+	if comment.EditedAt != nil {
+		prevAt = *comment.EditedAt
+	}
+	m.Revisions[id] = append(m.Revisions[id], &model.CommentRevision{Body: comment.Body, CreatedAt: prevAt})
+
+	comment.Body = body
+	comment.EditedAt = &editedAt
+	comment.Version++
+	m.index.indexComment(comment)
+	if err := m.log(walRecord{Op: opUpdateCommentBody, ID: id, Body: &body, At: &editedAt}); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+func (m *MemStore) ListCommentRevisions(ctx context.Context, commentID string) ([]*model.CommentRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revs := m.Revisions[commentID]
+	out := make([]*model.CommentRevision, len(revs))
+	copy(out, revs)
+	return out, nil
+}
+
+func (m *MemStore) DeleteComment(ctx context.Context, id string, deletedAt time.Time, expectedVersion *int) (*model.Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, ok := m.Comments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(expectedVersion, comment.Version); err != nil {
+		return nil, err
+	}
+
+	m.saveComment(id)
+	// удалённый комментарий остаётся в ветке, но не в счётчике поста
+	if post := m.Posts[comment.PostID]; post != nil && !comment.Deleted {
+		m.savePost(post.ID)
+		post.CommentsCount--
+	}
+	if comment.DeletedAt == nil {
+		comment.DeletedAt = &deletedAt
+	}
+	comment.Deleted = true
+	comment.Version++
+	m.index.removeComment(id)
+	if err := m.log(walRecord{Op: opDeleteComment, ID: id, At: &deletedAt}); err != nil {
+		return nil, err
+	}
+	return hideDeleted(comment), nil
+}
+
+func (m *MemStore) LockComment(ctx context.Context, id string, locked bool, expectedVersion *int) (*model.Comment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comment, ok := m.Comments[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion(expectedVersion, comment.Version); err != nil {
+		return nil, err
+	}
+
+	m.saveComment(id)
+	comment.Locked = locked
+	comment.Version++
+	if err := m.log(walRecord{Op: opLockComment, ID: id, Locked: locked}); err != nil {
+		return nil, err
+	}
+	return hideDeleted(comment), nil
+}
+
+func (m *MemStore) Search(ctx context.Context, query string, after *string, limit int) (*model.SearchPage, error) {
+	offset := 0
+	if after != nil && *after != "" {
+		var ok bool
+		if offset, ok = decodeOffsetCursor(*after); !ok {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	terms := tokenize(query)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	docs := m.index.match(terms)
+	if offset > len(docs) {
+		offset = len(docs)
+	}
+	end := offset + limit
+	if end > len(docs) {
+		end = len(docs)
+	}
+
+	edges := make([]*model.SearchEdge, 0, end-offset)
+	for i, doc := range docs[offset:end] {
+		hit := &model.SearchHit{Kind: doc.key.kind, Score: doc.score}
+		switch doc.key.kind {
+		case model.SearchHitKindPost:
+			hit.Post = m.Posts[doc.key.id]
+			hit.Snippet = highlight(hit.Post.Title+" "+hit.Post.Body, terms)
+		case model.SearchHitKindComment:
+			hit.Comment = m.Comments[doc.key.id]
+			hit.Post = m.Posts[hit.Comment.PostID]
+			hit.Snippet = highlight(hit.Comment.Body, terms)
+		}
+		edges = append(edges, &model.SearchEdge{Cursor: encodeOffsetCursor(offset + i + 1), Node: hit})
+	}
+
+	pageInfo := &model.PageInfo{HasNextPage: end < len(docs), HasPreviousPage: offset > 0}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = &edges[0].Cursor
+		pageInfo.EndCursor = &edges[len(edges)-1].Cursor
+	}
+
+	return &model.SearchPage{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+func (m *MemStore) React(ctx context.Context, postID string, commentID *string, user string, kind model.ReactionKind, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	target := postID
+	if commentID != nil {
+		target = *commentID
+	}
+
+	m.saveReactions(target)
+	byKind := m.Reactions[target]
+	if byKind == nil {
+		byKind = map[model.ReactionKind]map[string]struct{}{}
+		m.Reactions[target] = byKind
+	}
+	users := byKind[kind]
+	if users == nil {
+		users = map[string]struct{}{}
+		byKind[kind] = users
+	}
+	users[user] = struct{}{}
+	return m.log(walRecord{Op: opReact, ID: postID, CommentID: commentID, User: user, Kind: kind, At: &at})
+}
+
+func (m *MemStore) Unreact(ctx context.Context, targetID string, user string, kind model.ReactionKind) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveReactions(targetID)
+	users := m.Reactions[targetID][kind]
+	delete(users, user)
+	if len(users) == 0 {
+		delete(m.Reactions[targetID], kind)
+	}
+	if len(m.Reactions[targetID]) == 0 {
+		delete(m.Reactions, targetID)
+	}
+	return m.log(walRecord{Op: opUnreact, ID: targetID, User: user, Kind: kind})
+}
+
+func (m *MemStore) BatchReactions(ctx context.Context, targetIDs []string, viewer string) (map[string][]*model.ReactionCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string][]*model.ReactionCount, len(targetIDs))
+	for _, id := range targetIDs {
+		counts := []*model.ReactionCount{}
+		for kind, users := range m.Reactions[id] {
+			_, mine := users[viewer]
+			counts = append(counts, &model.ReactionCount{Kind: kind, Count: len(users), ViewerHasReacted: mine})
 		}
+		sortReactionCounts(counts)
+		out[id] = counts
 	}
 	return out, nil
 }
+
+func (m *MemStore) Export(ctx context.Context, fn func(Record) error) error {
+	// копируем указатели, чтобы не держать блокировку, пока запись уходит на диск
+	m.mu.RLock()
+	posts := make([]*model.Post, 0, len(m.Posts))
+	for _, p := range m.Posts {
+		posts = append(posts, p)
+	}
+	comments := make([]*model.Comment, 0, len(m.Comments))
+	for _, c := range m.Comments {
+		comments = append(comments, hideDeleted(c))
+	}
+	tags := make(map[string][]string, len(m.Tags))
+	for id, t := range m.Tags {
+		tags[id] = slices.Clone(t)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(posts, func(i, j int) bool { return postBefore(posts[j], posts[i]) })
+	// по глубине: родитель всегда на уровень выше ответа
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].Depth != comments[j].Depth {
+			return comments[i].Depth < comments[j].Depth
+		}
+		return commentBefore(comments[i], comments[j])
+	})
+
+	for _, p := range posts {
+		if err := fn(Record{Type: RecordPost, Post: p, Tags: tags[p.ID]}); err != nil {
+			return err
+		}
+	}
+	for _, c := range comments {
+		if err := fn(Record{Type: RecordComment, Comment: c}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemStore) Import(ctx context.Context, records []Record) (int, error) {
+	posts, comments, err := importBatch(records)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, rec := range posts {
+		m.mu.RLock()
+		_, exists := m.Posts[rec.Post.ID]
+		m.mu.RUnlock()
+		if exists {
+			continue
+		}
+
+		post := *rec.Post
+		// счётчик наберётся заново по мере импорта комментариев
+		post.CommentsCount = 0
+		if err := m.CreatePost(ctx, &post); err != nil {
+			return added, err
+		}
+		if len(rec.Tags) > 0 {
+			if err := m.SetPostTags(ctx, post.ID, rec.Tags); err != nil {
+				return added, err
+			}
+		}
+		added++
+	}
+
+	for _, c := range comments {
+		m.mu.RLock()
+		_, exists := m.Comments[c.ID]
+		_, hasPost := m.Posts[c.PostID]
+		hasParent := c.ParentID == nil || m.Comments[*c.ParentID] != nil
+		m.mu.RUnlock()
+		if exists {
+			continue
+		}
+		if !hasPost || !hasParent {
+			return added, fmt.Errorf("comment %s: post or parent comment: %w", c.ID, ErrNotFound)
+		}
+
+		comment := *c
+		// выгрузки без deletedAt считают комментарий удалённым в момент создания, как и PostgresStore
+		if comment.Deleted && comment.DeletedAt == nil {
+			at := comment.CreatedAt
+			comment.DeletedAt = &at
+		}
+		comment.Deleted = comment.DeletedAt != nil
+		if err := m.CreateComment(ctx, &comment); err != nil {
+			return added, err
+		}
+		added++
+	}
+	return added, nil
+}
+
+func (m *MemStore) GetUsers(ctx context.Context, ids []string) (map[string]*model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string]*model.User, len(ids))
+	for _, id := range ids {
+		if u, ok := m.Users[id]; ok {
+			out[id] = u
+		}
+	}
+	return out, nil
+}
+
+func (m *MemStore) EnsureUser(ctx context.Context, name string, at time.Time) (*model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.Users[UserID(name)]; ok {
+		return u, nil
+	}
+	m.ensureUser(name, at)
+	return m.Users[UserID(name)], m.log(walRecord{Op: opEnsureUser, User: name, At: &at})
+}
+
+func (m *MemStore) SetUserRole(ctx context.Context, name string, role model.Role, at time.Time) (*model.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ensureUser(name, at)
+	m.saveUser(UserID(name))
+	u := m.Users[UserID(name)]
+	u.Role = role
+	return u, m.log(walRecord{Op: opSetUserRole, User: name, Role: role, At: &at})
+}
+
+func (m *MemStore) CreateAPIToken(ctx context.Context, t *model.APIToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.saveAPIToken(t.ID)
+	m.APITokens[t.ID] = t
+	return m.log(walRecord{Op: opCreateAPIToken, APIToken: t})
+}
+
+func (m *MemStore) GetAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// токенов у пользователей единицы, поэтому отдельный индекс по хэшу не заводим
+	for _, t := range m.APITokens {
+		if t.Hash == hash {
+			out := *t
+			return &out, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemStore) ListAPITokens(ctx context.Context, user string) ([]*model.APIToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := []*model.APIToken{}
+	for _, t := range m.APITokens {
+		if t.User == user {
+			c := *t
+			out = append(out, &c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID > out[j].ID
+		}
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+func (m *MemStore) RevokeAPIToken(ctx context.Context, user string, id string, at time.Time) (*model.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.APITokens[id]
+	if !ok || t.User != user {
+		return nil, ErrNotFound
+	}
+	if t.RevokedAt == nil {
+		m.saveAPIToken(id)
+		t.RevokedAt = &at
+		if err := m.log(walRecord{Op: opRevokeAPIToken, ID: id, User: user, At: &at}); err != nil {
+			return nil, err
+		}
+	}
+	out := *t
+	return &out, nil
+}
+
+func (m *MemStore) ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// ключ без результата остался от мутации, прерванной до SetIdempotencyResult, поэтому занимаем его заново
+	if cur, ok := m.IdempotencyKeys[idempotencyID(k.User, k.Key)]; ok && cur.Result != nil && !cur.CreatedAt.Before(since) {
+		out := *cur
+		return &out, nil
+	}
+
+	k.Result = nil
+	m.saveIdempotencyKey(idempotencyID(k.User, k.Key))
+	m.IdempotencyKeys[idempotencyID(k.User, k.Key)] = &k
+	return nil, m.log(walRecord{Op: opClaimIdempotencyKey, Idempotency: &k, At: &since})
+}
+
+func (m *MemStore) SetIdempotencyResult(ctx context.Context, user string, key string, result []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.IdempotencyKeys[idempotencyID(user, key)]
+	if !ok {
+		return ErrNotFound
+	}
+	m.saveIdempotencyKey(idempotencyID(user, key))
+	k.Result = result
+	return m.log(walRecord{Op: opSetIdempotencyResult, Idempotency: &IdempotencyKey{User: user, Key: key, Result: result}})
+}
+
+func (m *MemStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, k := range m.IdempotencyKeys {
+		if k.CreatedAt.Before(before) {
+			m.saveIdempotencyKey(id)
+			delete(m.IdempotencyKeys, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, m.log(walRecord{Op: opPurgeIdempotencyKeys, At: &before})
+}
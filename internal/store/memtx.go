@@ -0,0 +1,195 @@
+package store
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+)
+
+// memUndo — прежние значения записей, изменённых внутри WithTx, и ещё не записанные в журнал мутации.
+// При ошибке fn записи возвращаются на место, а журнал не трогается, как при откате транзакции в PostgreSQL.
+type memUndo struct {
+	posts     map[string]undoEntry[*model.Post]
+	comments  map[string]undoEntry[*model.Comment]
+	revisions map[string]undoEntry[[]*model.CommentRevision]
+	reactions map[string]undoEntry[map[model.ReactionKind]map[string]struct{}]
+	tags      map[string]undoEntry[[]string]
+	keys      map[string]undoEntry[*IdempotencyKey]
+	users     map[string]undoEntry[*model.User]
+	tokens    map[string]undoEntry[*model.APIToken]
+
+	wal []walRecord
+}
+
+// undoEntry — значение записи до первой правки в транзакции; ok = false, если записи не было.
+type undoEntry[V any] struct {
+	v  V
+	ok bool
+}
+
+func newMemUndo() *memUndo {
+	return &memUndo{
+		posts:     map[string]undoEntry[*model.Post]{},
+		comments:  map[string]undoEntry[*model.Comment]{},
+		revisions: map[string]undoEntry[[]*model.CommentRevision]{},
+		reactions: map[string]undoEntry[map[model.ReactionKind]map[string]struct{}]{},
+		tags:      map[string]undoEntry[[]string]{},
+		keys:      map[string]undoEntry[*IdempotencyKey]{},
+		users:     map[string]undoEntry[*model.User]{},
+		tokens:    map[string]undoEntry[*model.APIToken]{},
+	}
+}
+
+// save запоминает копию записи id из m, если в этой транзакции она ещё не менялась.
+func save[V any](undo map[string]undoEntry[V], m map[string]V, id string, clone func(V) V) {
+	if _, seen := undo[id]; seen {
+		return
+	}
+	v, ok := m[id]
+	if ok {
+		v = clone(v)
+	}
+	undo[id] = undoEntry[V]{v: v, ok: ok}
+}
+
+func restore[V any](undo map[string]undoEntry[V], m map[string]V) {
+	for id, e := range undo {
+		if e.ok {
+			m[id] = e.v
+		} else {
+			delete(m, id)
+		}
+	}
+}
+
+func clonePtr[T any](p *T) *T {
+	c := *p
+	return &c
+}
+
+func cloneReactions(byKind map[model.ReactionKind]map[string]struct{}) map[model.ReactionKind]map[string]struct{} {
+	out := make(map[model.ReactionKind]map[string]struct{}, len(byKind))
+	for kind, users := range byKind {
+		out[kind] = maps.Clone(users)
+	}
+	return out
+}
+
+// Методы save* вызываются мутациями до правки записи; вне WithTx ничего не делают.
+
+func (s *memState) savePost(id string) {
+	if s.undo != nil {
+		save(s.undo.posts, s.Posts, id, clonePtr[model.Post])
+	}
+}
+
+func (s *memState) saveComment(id string) {
+	if s.undo != nil {
+		save(s.undo.comments, s.Comments, id, clonePtr[model.Comment])
+		save(s.undo.revisions, s.Revisions, id, slices.Clone[[]*model.CommentRevision])
+		save(s.undo.reactions, s.Reactions, id, cloneReactions)
+	}
+}
+
+func (s *memState) saveReactions(target string) {
+	if s.undo != nil {
+		save(s.undo.reactions, s.Reactions, target, cloneReactions)
+	}
+}
+
+func (s *memState) saveTags(postID string) {
+	if s.undo != nil {
+		save(s.undo.tags, s.Tags, postID, slices.Clone[[]string])
+	}
+}
+
+func (s *memState) saveIdempotencyKey(id string) {
+	if s.undo != nil {
+		save(s.undo.keys, s.IdempotencyKeys, id, clonePtr[IdempotencyKey])
+	}
+}
+
+func (s *memState) saveUser(id string) {
+	if s.undo != nil {
+		save(s.undo.users, s.Users, id, clonePtr[model.User])
+	}
+}
+
+func (s *memState) saveAPIToken(id string) {
+	if s.undo != nil {
+		save(s.undo.tokens, s.APITokens, id, clonePtr[model.APIToken])
+	}
+}
+
+// rollback возвращает записи, изменённые в транзакции, и поправляет ленту, ветки и поисковый индекс
+// только для них. Если fn ничего не записала, откатывать нечего.
+func (s *memState) rollback() {
+	u := s.undo
+	s.undo = nil
+	if u.empty() {
+		return
+	}
+
+	// сначала из индексов убираются текущие версии затронутых записей, после восстановления — добавляются прежние
+	for id := range u.posts {
+		if p := s.Posts[id]; p != nil {
+			s.removeFromFeed(p)
+			s.index.removePost(id)
+		}
+	}
+	for id := range u.comments {
+		if c := s.Comments[id]; c != nil {
+			s.removeFromThread(c)
+			s.index.removeComment(id)
+		}
+	}
+
+	restore(u.posts, s.Posts)
+	restore(u.comments, s.Comments)
+	restore(u.revisions, s.Revisions)
+	restore(u.reactions, s.Reactions)
+	restore(u.tags, s.Tags)
+	restore(u.keys, s.IdempotencyKeys)
+	restore(u.users, s.Users)
+	restore(u.tokens, s.APITokens)
+
+	for _, e := range u.posts {
+		if e.ok {
+			s.insertInFeed(e.v)
+			s.index.indexPost(e.v)
+		}
+	}
+	for _, e := range u.comments {
+		if e.ok {
+			s.insertInThread(e.v)
+			s.index.indexComment(e.v)
+		}
+	}
+}
+
+func (u *memUndo) empty() bool {
+	return len(u.posts) == 0 && len(u.comments) == 0 && len(u.revisions) == 0 && len(u.reactions) == 0 &&
+		len(u.tags) == 0 && len(u.keys) == 0 && len(u.users) == 0 && len(u.tokens) == 0
+}
+
+// thread возвращает индекс ветки, в котором лежит c, и ключ в нём: корни поста или ответы на родителя.
+func (s *memState) thread(c *model.Comment) (map[string][]*model.Comment, string) {
+	if c.ParentID == nil {
+		return s.roots, c.PostID
+	}
+	return s.replies, *c.ParentID
+}
+
+func (s *memState) insertInThread(c *model.Comment) {
+	idx, key := s.thread(c)
+	idx[key] = insertByTime(idx[key], c)
+}
+
+func (s *memState) removeFromThread(c *model.Comment) {
+	idx, key := s.thread(c)
+	idx[key] = slices.DeleteFunc(idx[key], func(x *model.Comment) bool { return x.ID == c.ID })
+	if len(idx[key]) == 0 {
+		delete(idx, key)
+	}
+}
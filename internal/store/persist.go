@@ -0,0 +1,472 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+)
+
+// Файлы каталога данных MemStore.
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+
+	defaultSnapshotEvery = 1000
+)
+
+type walOp string
+
+const (
+	opCreatePost        walOp = "createPost"
+	opCloseComments     walOp = "closeComments"
+	opUpdatePost        walOp = "updatePost"
+	opDeletePost        walOp = "deletePost"
+	opSetPostStatus     walOp = "setPostStatus"
+	opPublishDue        walOp = "publishDue"
+	opSetPostTags       walOp = "setPostTags"
+	opCreateComment     walOp = "createComment"
+	opUpdateCommentBody walOp = "updateCommentBody"
+	opDeleteComment     walOp = "deleteComment"
+	opLockComment       walOp = "lockComment"
+	opReact             walOp = "react"
+	opUnreact           walOp = "unreact"
+
+	opClaimIdempotencyKey  walOp = "claimIdempotencyKey"
+	opSetIdempotencyResult walOp = "setIdempotencyResult"
+	opPurgeIdempotencyKeys walOp = "purgeIdempotencyKeys"
+
+	opEnsureUser     walOp = "ensureUser"
+	opCreateAPIToken walOp = "createApiToken"
+	opRevokeAPIToken walOp = "revokeApiToken"
+	opSetUserRole    walOp = "setUserRole"
+)
+
+// walRecord — одна запись журнала: аргументы мутации MemStore. Заполнены только поля, нужные для Op.
+type walRecord struct {
+	Seq       uint64             `json:"seq"`
+	Op        walOp              `json:"op"`
+	Post      *model.Post        `json:"post,omitempty"`
+	Comment   *model.Comment     `json:"comment,omitempty"`
+	ID        string             `json:"id,omitempty"`
+	CommentID *string            `json:"commentId,omitempty"`
+	Title     *string            `json:"title,omitempty"`
+	Body      *string            `json:"body,omitempty"`
+	Closed    bool               `json:"closed,omitempty"`
+	Locked    bool               `json:"locked,omitempty"`
+	Status    model.PostStatus   `json:"status,omitempty"`
+	Tags      []string           `json:"tags,omitempty"`
+	User      string             `json:"user,omitempty"`
+	Kind      model.ReactionKind `json:"kind,omitempty"`
+	Role      model.Role         `json:"role,omitempty"`
+	At        *time.Time         `json:"at,omitempty"`
+
+	Idempotency *IdempotencyKey `json:"idempotency,omitempty"`
+	APIToken    *model.APIToken `json:"apiToken,omitempty"`
+}
+
+// memSnapshot — полное состояние MemStore; Seq — последняя вошедшая в него запись журнала.
+type memSnapshot struct {
+	Seq       uint64                                     `json:"seq"`
+	Posts     []*model.Post                              `json:"posts"`
+	Comments  []*model.Comment                           `json:"comments"`
+	Revisions map[string][]*model.CommentRevision        `json:"revisions"`
+	Reactions map[string]map[model.ReactionKind][]string `json:"reactions"`
+	Tags      map[string][]string                        `json:"tags"`
+
+	IdempotencyKeys []*IdempotencyKey `json:"idempotencyKeys,omitempty"`
+	Users           []*model.User     `json:"users,omitempty"`
+	APITokens       []*model.APIToken `json:"apiTokens,omitempty"`
+}
+
+// walLog — журнал изменений в каталоге данных. Защищается мьютексом MemStore.
+type walLog struct {
+	dir           string
+	file          *os.File
+	seq           uint64
+	sinceSnapshot int
+	snapshotEvery int
+}
+
+// OpenMemStore возвращает MemStore, который хранит данные в каталоге dir: каждая мутация дописывается в журнал,
+// а каждые snapshotEvery записей (0 — по умолчанию) состояние сохраняется снимком и журнал обнуляется.
+// При открытии читается снимок и поверх него проигрывается журнал. Close сбрасывает журнал на диск и сжимает его.
+func OpenMemStore(dir string, snapshotEvery int) (Store, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	m := NewMemStore().(*MemStore)
+	seq, err := m.loadSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, fmt.Errorf("load snapshot: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := m.replay(f, seq)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("replay wal: %w", err)
+	}
+	if replayed.seq > seq {
+		seq = replayed.seq
+	}
+
+	m.wal = &walLog{dir: dir, file: f, seq: seq, sinceSnapshot: replayed.count, snapshotEvery: snapshotEvery}
+	return m, nil
+}
+
+type replayResult struct {
+	seq   uint64
+	count int
+}
+
+// replay применяет записи журнала новее снимка. Недописанная последняя строка (падение посреди записи)
+// отбрасывается, повреждённая строка в середине — ошибка.
+func (m *MemStore) replay(f *os.File, after uint64) (replayResult, error) {
+	var res replayResult
+	ctx := context.Background()
+
+	r := bufio.NewReader(f)
+	var good int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				// хвост без перевода строки не был дописан до конца
+				if err := f.Truncate(good); err != nil {
+					return res, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return res, fmt.Errorf("record at offset %d: %w", good, err)
+		}
+		good += int64(len(line))
+
+		if rec.Seq <= after {
+			continue
+		}
+		if err := m.apply(ctx, rec); err != nil && !errors.Is(err, ErrNotFound) {
+			return res, fmt.Errorf("record %d (%s): %w", rec.Seq, rec.Op, err)
+		}
+		res.seq = rec.Seq
+		res.count++
+	}
+
+	_, err := f.Seek(0, io.SeekEnd)
+	return res, err
+}
+
+// apply повторяет мутацию из журнала; вызывается, пока журнал не подключён, поэтому ничего не пишет.
+func (m *MemStore) apply(ctx context.Context, rec walRecord) error {
+	var err error
+	switch rec.Op {
+	case opCreatePost:
+		err = m.CreatePost(ctx, rec.Post)
+	case opCloseComments:
+		_, err = m.CloseComments(ctx, rec.ID, rec.Closed, nil)
+	case opUpdatePost:
+		_, err = m.UpdatePost(ctx, rec.ID, rec.Title, rec.Body, nil)
+	case opDeletePost:
+		err = m.DeletePost(ctx, rec.ID, nil)
+	case opSetPostStatus:
+		_, err = m.SetPostStatus(ctx, rec.ID, rec.Status, rec.At, nil)
+	case opPublishDue:
+		_, err = m.PublishDue(ctx, *rec.At)
+	case opSetPostTags:
+		err = m.SetPostTags(ctx, rec.ID, rec.Tags)
+	case opCreateComment:
+		err = m.CreateComment(ctx, rec.Comment)
+	case opUpdateCommentBody:
+		_, err = m.UpdateCommentBody(ctx, rec.ID, *rec.Body, *rec.At, nil)
+	case opDeleteComment:
+		_, err = m.DeleteComment(ctx, rec.ID, *rec.At, nil)
+	case opLockComment:
+		_, err = m.LockComment(ctx, rec.ID, rec.Locked, nil)
+	case opReact:
+		err = m.React(ctx, rec.ID, rec.CommentID, rec.User, rec.Kind, *rec.At)
+	case opUnreact:
+		err = m.Unreact(ctx, rec.ID, rec.User, rec.Kind)
+	case opClaimIdempotencyKey:
+		_, err = m.ClaimIdempotencyKey(ctx, *rec.Idempotency, *rec.At)
+	case opSetIdempotencyResult:
+		err = m.SetIdempotencyResult(ctx, rec.Idempotency.User, rec.Idempotency.Key, rec.Idempotency.Result)
+	case opPurgeIdempotencyKeys:
+		_, err = m.PurgeIdempotencyKeys(ctx, *rec.At)
+	case opEnsureUser:
+		_, err = m.EnsureUser(ctx, rec.User, *rec.At)
+	case opCreateAPIToken:
+		err = m.CreateAPIToken(ctx, rec.APIToken)
+	case opRevokeAPIToken:
+		_, err = m.RevokeAPIToken(ctx, rec.User, rec.ID, *rec.At)
+	case opSetUserRole:
+		_, err = m.SetUserRole(ctx, rec.User, rec.Role, *rec.At)
+	default:
+		err = fmt.Errorf("unknown op %q", rec.Op)
+	}
+	return err
+}
+
+// log дописывает мутацию в журнал; без каталога данных ничего не делает. Вызывается под m.mu.
+// Внутри WithTx запись только откладывается: в журнал она попадёт, если транзакция завершится без ошибки.
+func (m *MemStore) log(rec walRecord) error {
+	if m.wal == nil {
+		return nil
+	}
+	if m.undo != nil {
+		// запись ссылается на живые посты и комментарии: сохраняем её в том виде, в каком она сделана сейчас
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		var saved walRecord
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+		m.undo.wal = append(m.undo.wal, saved)
+		return nil
+	}
+
+	if err := m.appendWAL([]walRecord{rec}); err != nil {
+		return err
+	}
+	return m.maybeCompact()
+}
+
+// appendWAL дописывает записи в журнал одним вызовом Write и только потом сдвигает seq. Если запись не удалась,
+// журнал обрезается до прежней длины, чтобы при проигрывании не всплыла часть пачки. Вызывается под m.mu.
+func (m *MemStore) appendWAL(recs []walRecord) error {
+	var buf bytes.Buffer
+	seq := m.wal.seq
+	for _, rec := range recs {
+		seq++
+		rec.Seq = seq
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	off, err := m.wal.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := m.wal.file.Write(buf.Bytes()); err != nil {
+		if terr := m.wal.file.Truncate(off); terr == nil {
+			_, _ = m.wal.file.Seek(off, io.SeekStart)
+		}
+		return err
+	}
+	m.wal.seq = seq
+	m.wal.sinceSnapshot += len(recs)
+	return nil
+}
+
+// maybeCompact сжимает журнал, когда в нём набралось snapshotEvery записей. Вызывается после целой пачки,
+// иначе снимок посреди транзакции уже содержал бы её оставшиеся записи, и при открытии они применились бы дважды.
+func (m *MemStore) maybeCompact() error {
+	if m.wal.sinceSnapshot >= m.wal.snapshotEvery {
+		return m.compact()
+	}
+	return nil
+}
+
+// compact сохраняет снимок состояния и обнуляет журнал. Вызывается под m.mu.
+func (m *MemStore) compact() error {
+	if err := m.wal.file.Sync(); err != nil {
+		return err
+	}
+	if err := m.writeSnapshot(); err != nil {
+		return err
+	}
+	// записи журнала уже в снимке; если упадём до обрезки, их отсеет Seq
+	if err := m.wal.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := m.wal.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	m.wal.sinceSnapshot = 0
+	return m.wal.file.Sync()
+}
+
+func (m *MemStore) writeSnapshot() error {
+	snap := memSnapshot{
+		Seq:       m.wal.seq,
+		Posts:     make([]*model.Post, 0, len(m.Posts)),
+		Comments:  make([]*model.Comment, 0, len(m.Comments)),
+		Revisions: m.Revisions,
+		Reactions: make(map[string]map[model.ReactionKind][]string, len(m.Reactions)),
+		Tags:      m.Tags,
+	}
+	for _, p := range m.Posts {
+		snap.Posts = append(snap.Posts, p)
+	}
+	for _, c := range m.Comments {
+		snap.Comments = append(snap.Comments, c)
+	}
+	for _, k := range m.IdempotencyKeys {
+		snap.IdempotencyKeys = append(snap.IdempotencyKeys, k)
+	}
+	for _, u := range m.Users {
+		snap.Users = append(snap.Users, u)
+	}
+	for _, t := range m.APITokens {
+		snap.APITokens = append(snap.APITokens, t)
+	}
+	for target, byKind := range m.Reactions {
+		kinds := make(map[model.ReactionKind][]string, len(byKind))
+		for kind, users := range byKind {
+			for user := range users {
+				kinds[kind] = append(kinds[kind], user)
+			}
+		}
+		snap.Reactions[target] = kinds
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	// пишем во временный файл и переименовываем, чтобы на диске всегда был целый снимок
+	path := filepath.Join(m.wal.dir, snapshotFile)
+	tmp, err := os.CreateTemp(m.wal.dir, snapshotFile+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(m.wal.dir)
+}
+
+// loadSnapshot восстанавливает состояние из снимка, если он есть, и возвращает его Seq.
+func (m *MemStore) loadSnapshot(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var snap memSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, err
+	}
+
+	for _, u := range snap.Users {
+		// в снимках до появления ролей роли нет
+		if u.Role == "" {
+			u.Role = model.RoleUser
+		}
+		m.Users[u.ID] = u
+	}
+	for _, t := range snap.APITokens {
+		m.APITokens[t.ID] = t
+	}
+	// в снимках до появления пользователей их нет: заводим по авторам
+	for _, p := range snap.Posts {
+		m.ensureUser(p.Author, p.CreatedAt)
+		m.Posts[p.ID] = p
+		m.postsByTime = append(m.postsByTime, p)
+		m.index.indexPost(p)
+	}
+	sort.Slice(m.postsByTime, func(i, j int) bool { return postBefore(m.postsByTime[i], m.postsByTime[j]) })
+
+	for _, c := range snap.Comments {
+		m.ensureUser(c.Author, c.CreatedAt)
+		m.Comments[c.ID] = c
+		if c.ParentID == nil {
+			m.roots[c.PostID] = insertByTime(m.roots[c.PostID], c)
+		} else {
+			m.replies[*c.ParentID] = insertByTime(m.replies[*c.ParentID], c)
+		}
+		m.index.indexComment(c)
+	}
+
+	for id, revs := range snap.Revisions {
+		m.Revisions[id] = revs
+	}
+	for target, kinds := range snap.Reactions {
+		byKind := map[model.ReactionKind]map[string]struct{}{}
+		for kind, users := range kinds {
+			set := make(map[string]struct{}, len(users))
+			for _, u := range users {
+				set[u] = struct{}{}
+			}
+			byKind[kind] = set
+		}
+		m.Reactions[target] = byKind
+	}
+	for id, tags := range snap.Tags {
+		m.Tags[id] = tags
+	}
+	for _, k := range snap.IdempotencyKeys {
+		m.IdempotencyKeys[idempotencyID(k.User, k.Key)] = k
+	}
+	return snap.Seq, nil
+}
+
+// Close сбрасывает журнал на диск и сжимает его в снимок. Для MemStore без каталога данных ничего не делает.
+func (m *MemStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.wal == nil {
+		return nil
+	}
+	err := m.compact()
+	if cerr := m.wal.file.Close(); err == nil {
+		err = cerr
+	}
+	m.wal = nil
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
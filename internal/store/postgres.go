@@ -4,8 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -14,7 +16,16 @@ import (
 )
 
 type PostgresStore struct {
-	db *sql.DB
+	pool *sql.DB
+	// пул или транзакция WithTx — через него идут все запросы
+	db dbtx
+	tx *sql.Tx
+}
+
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 func NewPostgres(dsn string) (Store, error) {
@@ -35,74 +46,393 @@ func NewPostgres(dsn string) (Store, error) {
 		return nil, err
 	}
 
-	return &PostgresStore{db: db}, nil
+	return &PostgresStore{pool: db, db: db}, nil
 }
 
 func (p *PostgresStore) Close() error {
-	if p == nil || p.db == nil {
+	// хранилище внутри WithTx не владеет пулом
+	if p == nil || p.pool == nil || p.tx != nil {
+		return nil
+	}
+	return p.pool.Close()
+}
+
+// WithTx выполняет fn в одной транзакции. Внутри неё GetPost и GetComment берут строку под for update,
+// так что проверка и следующая за ней запись видят одно и то же состояние. Вложенный WithTx идёт в ту же транзакцию.
+func (p *PostgresStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if p.tx != nil {
+		return fn(p)
+	}
+
+	tx, err := p.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := fn(&PostgresStore{pool: p.pool, db: tx, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// pgTx — транзакция метода хранилища. Внутри WithTx это внешняя транзакция, и Commit/Rollback оставляют её WithTx.
+type pgTx struct {
+	dbtx
+	own *sql.Tx
+}
+
+func (t pgTx) Commit() error {
+	if t.own == nil {
 		return nil
 	}
-	return p.db.Close()
+	return t.own.Commit()
+}
+
+func (t pgTx) Rollback() error {
+	if t.own == nil {
+		return nil
+	}
+	return t.own.Rollback()
+}
+
+func (p *PostgresStore) begin(ctx context.Context) (pgTx, error) {
+	if p.tx != nil {
+		return pgTx{dbtx: p.tx}, nil
+	}
+	tx, err := p.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return pgTx{}, err
+	}
+	return pgTx{dbtx: tx, own: tx}, nil
+}
+
+// lockRow — суффикс точечного чтения: внутри WithTx строка блокируется до конца транзакции.
+func (p *PostgresStore) lockRow() string {
+	if p.tx != nil {
+		return ` for update`
+	}
+	return ``
 }
 
 func (p *PostgresStore) CreatePost(ctx context.Context, post *model.Post) error {
-	const q = `insert into posts (id, title, body, author, comments_closed, created_at)
-	values ($1, $2, $3, $4, $5, $6)`
+	defaultPostStatus(post)
+	if post.Version == 0 {
+		post.Version = 1
+	}
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	_, err := p.db.ExecContext(ctx, q, post.ID, post.Title, post.Body, post.Author, post.CommentsClosed, post.CreatedAt)
+	if err := ensureUser(ctx, tx, post.Author, post.CreatedAt); err != nil {
+		return err
+	}
+
+	const q = `insert into posts (id, title, body, author, comments_closed, created_at, status, publish_at, version)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	if _, err := tx.ExecContext(ctx, q, post.ID, post.Title, post.Body, post.Author, post.CommentsClosed, post.CreatedAt,
+		string(post.Status), post.PublishAt, post.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureUser заводит пользователя name, если его ещё нет.
+func ensureUser(ctx context.Context, db dbtx, name string, at time.Time) error {
+	const q = `insert into users (id, name, created_at) values ($1, $2, $3) on conflict do nothing`
+	_, err := db.ExecContext(ctx, q, UserID(name), name, at)
 	return err
 }
 
 func (p *PostgresStore) GetPost(ctx context.Context, id string) (*model.Post, error) {
-	const q = `select id, title, body, author, comments_closed, created_at, 
-       (select count(*) from comments c where c.post_id = posts.id) as comments_count from posts where id = $1`
-
-	var res model.Post
+	q := `select ` + postColumns + ` from posts where id = $1` + p.lockRow()
 
-	if err := p.db.QueryRowContext(ctx, q, id).Scan(&res.ID, &res.Title, &res.Body, &res.Author, &res.CommentsClosed, &res.CreatedAt, &res.CommentsCount); err != nil {
+	res, err := scanPost(p.db.QueryRowContext(ctx, q, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	return &res, nil
+	return res, nil
 }
 
-func (p *PostgresStore) ListPosts(ctx context.Context) ([]*model.Post, error) {
-	const q = `select id, title, body, author, comments_closed, created_at,
-       (select count(*) from comments c where c.post_id = posts.id) as comments_count from posts order by created_at desc`
+func (p *PostgresStore) ListPosts(ctx context.Context, filter PostFilter, after *string, limit int) (*model.PostPage, error) {
+	var args []any
+	var conds []string
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if filter.Viewer != nil {
+		conds = append(conds, "(status = 'PUBLISHED' or author = "+arg(*filter.Viewer)+")")
+	} else {
+		conds = append(conds, "status = 'PUBLISHED'")
+	}
+	if filter.Author != nil {
+		conds = append(conds, "author = "+arg(*filter.Author))
+	}
+	if filter.Since != nil {
+		conds = append(conds, postFeedTime+" >= "+arg(*filter.Since))
+	}
+	if filter.Until != nil {
+		conds = append(conds, postFeedTime+" < "+arg(*filter.Until))
+	}
+	if filter.CommentsClosed != nil {
+		conds = append(conds, "comments_closed = "+arg(*filter.CommentsClosed))
+	}
+	if filter.Tag != nil {
+		conds = append(conds, `exists (select 1 from post_tags pt join tags t on t.id = pt.tag_id
+            where pt.post_id = posts.id and t.name = `+arg(*filter.Tag)+`)`)
+	}
+	// filterWhere — только фильтры, без курсора: по нему проверяется наличие предыдущей страницы
+	filterWhere, filterArgs := "true", slices.Clone(args)
+	if len(conds) > 0 {
+		filterWhere = strings.Join(conds, " and ")
+	}
+	hasPrev := false
+	if after != nil && *after != "" {
+		ts, id, ok := decodeCursor(*after)
+		if !ok {
+			return nil, ErrInvalidCursor
+		}
+		conds = append(conds, "("+postFeedTime+", id) < ("+arg(ts)+", "+arg(id)+")")
+
+		filterArgs = append(filterArgs, ts, id)
+		prevQ := fmt.Sprintf(`select exists (select 1 from posts where %s and (%s, id) >= ($%d, $%d))`,
+			filterWhere, postFeedTime, len(filterArgs)-1, len(filterArgs))
+		if err := p.db.QueryRowContext(ctx, prevQ, filterArgs...).Scan(&hasPrev); err != nil {
+			return nil, err
+		}
+	}
+
+	where := "true"
+	if len(conds) > 0 {
+		where = strings.Join(conds, " and ")
+	}
+
+	// берём на одну строку больше, чтобы честно узнать hasNextPage
+	q := fmt.Sprintf(`
+    select %s
+    from posts where %s order by %s desc, id desc limit %d`,
+		postColumns, where, postFeedTime, limit+1)
 
-	rows, err := p.db.QueryContext(ctx, q)
+	rows, err := p.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var res []*model.Post
+	var items []*model.Post
 	for rows.Next() {
-		var row model.Post
+		row, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hasNext := len(items) > limit
+	if hasNext {
+		items = items[:limit]
+	}
+
+	edges := make([]*model.PostEdge, 0, len(items))
+	for _, it := range items {
+		edges = append(edges, &model.PostEdge{Cursor: encodeCursor(feedTime(it), it.ID), Node: it})
+	}
+
+	pageInfo := &model.PageInfo{HasNextPage: hasNext, HasPreviousPage: hasPrev}
+	if len(edges) > 0 {
+		start, end := edges[0].Cursor, edges[len(edges)-1].Cursor
+		pageInfo.StartCursor, pageInfo.EndCursor = &start, &end
+	}
+
+	return &model.PostPage{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+func (p *PostgresStore) CloseComments(ctx context.Context, id string, closed bool, expectedVersion *int) (*model.Post, error) {
+	q := `update posts set comments_closed = $2, version = version + 1
+    where id = $1 and ($3::int is null or version = $3) returning ` + postColumns
+
+	row, err := scanPost(p.db.QueryRowContext(ctx, q, id, closed, expectedVersion))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, p.versionMiss(ctx, "posts", id, expectedVersion)
+		}
+		return nil, err
+	}
+	return row, nil
+}
+
+func (p *PostgresStore) UpdatePost(ctx context.Context, id string, title *string, body *string, expectedVersion *int) (*model.Post, error) {
+	q := `update posts set title = coalesce($2, title), body = coalesce($3, body), version = version + 1
+    where id = $1 and ($4::int is null or version = $4) returning ` + postColumns
+
+	row, err := scanPost(p.db.QueryRowContext(ctx, q, id, title, body, expectedVersion))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, p.versionMiss(ctx, "posts", id, expectedVersion)
+		}
+		return nil, err
+	}
+	return row, nil
+}
+
+func (p *PostgresStore) DeletePost(ctx context.Context, id string, expectedVersion *int) error {
+	// комментарии и история правок уходят каскадом
+	const q = `delete from posts where id = $1 and ($2::int is null or version = $2)`
+
+	res, err := p.db.ExecContext(ctx, q, id, expectedVersion)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return p.versionMiss(ctx, "posts", id, expectedVersion)
+	}
+	return nil
+}
+
+func (p *PostgresStore) SetPostStatus(ctx context.Context, id string, status model.PostStatus, publishAt *time.Time, expectedVersion *int) (*model.Post, error) {
+	q := `update posts set status = $2, publish_at = $3, version = version + 1
+    where id = $1 and ($4::int is null or version = $4) returning ` + postColumns
+
+	row, err := scanPost(p.db.QueryRowContext(ctx, q, id, string(status), publishAt, expectedVersion))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, p.versionMiss(ctx, "posts", id, expectedVersion)
+		}
+		return nil, err
+	}
+	return row, nil
+}
+
+// versionMiss объясняет, почему правка не задела ни одной строки: записи нет или её версия уже другая.
+func (p *PostgresStore) versionMiss(ctx context.Context, table string, id string, expectedVersion *int) error {
+	if expectedVersion == nil {
+		return ErrNotFound
+	}
+	var actual int
+	err := p.db.QueryRowContext(ctx, `select version from `+table+` where id = $1`, id).Scan(&actual)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return checkVersion(expectedVersion, actual)
+}
+
+func (p *PostgresStore) PublishDue(ctx context.Context, now time.Time) ([]*model.Post, error) {
+	q := `update posts set status = 'PUBLISHED', version = version + 1
+    where status = 'SCHEDULED' and publish_at <= $1 returning ` + postColumns
+
+	rows, err := p.db.QueryContext(ctx, q, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		if err := rows.Scan(&row.ID, &row.Title, &row.Body, &row.Author, &row.CommentsClosed, &row.CreatedAt, &row.CommentsCount); err != nil {
+	var out []*model.Post
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
 			return nil, err
 		}
-		res = append(res, &row)
+		out = append(out, post)
 	}
-	return res, rows.Err()
+	return out, rows.Err()
 }
 
-func (p *PostgresStore) CloseComments(ctx context.Context, id string, closed bool) (*model.Post, error) {
-	const q = `update posts set comments_closed = $2 where id = $1
-			  returning id, title, body, author, comments_closed, created_at,
-			  (select count(*) from comments c where c.post_id = posts.id) as comments_count`
+func (p *PostgresStore) SetPostTags(ctx context.Context, postID string, tags []string) error {
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
 
-	var row model.Post
-	if err := p.db.QueryRowContext(ctx, q, id, closed).Scan(&row.ID, &row.Title, &row.Body, &row.Author, &row.CommentsClosed, &row.CreatedAt, &row.CommentsCount); err != nil {
+	// блокируем пост, чтобы параллельные правки тегов не перемешались
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `select true from posts where id = $1 for update`, postID).Scan(&exists); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotFound
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `delete from post_tags where post_id = $1`, postID); err != nil {
+		return err
+	}
+	if len(tags) > 0 {
+		const ensure = `insert into tags (name) select unnest($1::text[]) on conflict (name) do nothing`
+		if _, err := tx.ExecContext(ctx, ensure, pgArray(tags)); err != nil {
+			return err
+		}
+		const link = `insert into post_tags (post_id, tag_id) select $1, id from tags where name = any($2)`
+		if _, err := tx.ExecContext(ctx, link, postID, pgArray(tags)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (p *PostgresStore) BatchPostTags(ctx context.Context, postIDs []string) (map[string][]string, error) {
+	out := make(map[string][]string, len(postIDs))
+	if len(postIDs) == 0 {
+		return out, nil
+	}
+
+	const q = `select pt.post_id, t.name from post_tags pt join tags t on t.id = pt.tag_id
+    where pt.post_id = any($1) order by pt.post_id, t.name`
+	rows, err := p.db.QueryContext(ctx, q, pgArray(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pid, name string
+		if err := rows.Scan(&pid, &name); err != nil {
+			return nil, err
 		}
+		out[pid] = append(out[pid], name)
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) ListTags(ctx context.Context, limit int) ([]*model.Tag, error) {
+	// черновики и отложенные посты не считаются, как и в выдаче posts(tag:)
+	const q = `select t.name, count(*) from tags t
+        join post_tags pt on pt.tag_id = t.id
+        join posts p on p.id = pt.post_id and p.status = 'PUBLISHED'
+    group by t.name order by count(*) desc, t.name limit $1`
+	rows, err := p.db.QueryContext(ctx, q, limit)
+	if err != nil {
 		return nil, err
 	}
-	return &row, nil
+	defer rows.Close()
+
+	out := []*model.Tag{}
+	for rows.Next() {
+		tag := &model.Tag{}
+		if err := rows.Scan(&tag.Name, &tag.PostsCount); err != nil {
+			return nil, err
+		}
+		out = append(out, tag)
+	}
+	return out, rows.Err()
 }
 
 func (p *PostgresStore) CreateComment(ctx context.Context, comment *model.Comment) error {
@@ -110,10 +440,16 @@ func (p *PostgresStore) CreateComment(ctx context.Context, comment *model.Commen
 		comment.ParentID = nil
 	}
 
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
 	depth := 0
 	if comment.ParentID != nil {
 		const getDepth = `select depth from comments where id = $1`
-		if err := p.db.QueryRowContext(ctx, getDepth, *comment.ParentID).Scan(&depth); err != nil {
+		if err := tx.QueryRowContext(ctx, getDepth, *comment.ParentID).Scan(&depth); err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return ErrNotFound
 			}
@@ -122,51 +458,124 @@ func (p *PostgresStore) CreateComment(ctx context.Context, comment *model.Commen
 		depth++
 	}
 
-	const q = `insert into comments(id, post_id, parent_id, body, author, depth, created_at) values ($1, $2, $3, $4, $5, $6, $7)`
-	_, err := p.db.ExecContext(ctx, q, comment.ID, comment.PostID, comment.ParentID, comment.Body, comment.Author, depth, comment.CreatedAt)
-	if err == nil {
-		comment.Depth = depth
+	if err := ensureUser(ctx, tx, comment.Author, comment.CreatedAt); err != nil {
+		return err
 	}
-	return err
+
+	const q = `insert into comments(id, post_id, parent_id, reply_to_id, body, author, depth, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)`
+	if _, err := tx.ExecContext(ctx, q, comment.ID, comment.PostID, comment.ParentID, comment.ReplyToID,
+		comment.Body, comment.Author, depth, comment.CreatedAt); err != nil {
+		return err
+	}
+
+	// счётчик меняется в той же транзакции, что и вставка, поэтому не расходится с таблицей comments
+	const bump = `update posts set comments_count = comments_count + 1 where id = $1`
+	if _, err := tx.ExecContext(ctx, bump, comment.PostID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	comment.Depth = depth
+	comment.Version = 1
+	return nil
 }
 
 func (p *PostgresStore) GetComment(ctx context.Context, id string) (*model.Comment, error) {
-	const q = `select id, post_id, parent_id, author, body, depth, created_at from comments where id = $1`
+	q := `select ` + commentColumns + ` from comments where id = $1` + p.lockRow()
 
-	var c model.Comment
-	if err := p.db.QueryRowContext(ctx, q, id).Scan(
-		&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Body, &c.Depth, &c.CreatedAt,
-	); err != nil {
+	c, err := scanComment(p.db.QueryRowContext(ctx, q, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
 		return nil, err
 	}
-	return &c, nil
+	return c, nil
 }
 
-func (p *PostgresStore) ListComments(ctx context.Context, postID string, parentID *string, after *string, limit int) (*model.CommentPage, error) {
-	args := []any{postID}
-	where := `c.post_id = $1`
+// pgThreadPathElem повторяет threadPathElem: микросекунды фиксированной ширины и id.
+const pgThreadPathElem = `lpad((extract(epoch from c.created_at) * 1000000)::bigint::text, 20, '0') || c.id::text`
+
+func (p *PostgresStore) ListComments(ctx context.Context, cq CommentsQuery) (*model.CommentPage, error) {
+	args := []any{cq.PostID}
+
+	// src — все комментарии выборки с ключами сортировки score и path
+	var src string
+	if cq.Order == model.CommentOrderThreaded {
+		scope := `c.parent_id is null`
+		if cq.ParentID != nil {
+			args = append(args, *cq.ParentID)
+			scope = `c.parent_id = $2`
+		}
+		src = fmt.Sprintf(`(
+        with recursive t as (
+            select c.*, %[1]s as path
+            from comments c where c.post_id = $1 and %[2]s
+            union all
+            select c.*, t.path || '/' || %[1]s
+            from comments c join t on c.parent_id = t.id
+        )
+        select t.*, 0 as score from t
+    ) src`, pgThreadPathElem, scope)
+	} else {
+		where := `c.post_id = $1`
+		if cq.ParentID != nil {
+			args = append(args, *cq.ParentID)
+			where += ` and c.parent_id = $2`
+		}
+		score := `0`
+		if cq.Order == model.CommentOrderTop {
+			score = `(select count(*) from reactions r where r.target_id = c.id) +
+            (select count(*) from comments r where r.parent_id = c.id)`
+		}
+		src = fmt.Sprintf(`(select c.*, %s as score, '' as path from comments c where %s) src`, score, where)
+	}
 
-	if parentID != nil && *parentID != "" {
-		where += ` and c.parent_id = $2`
-		args = append(args, *parentID)
+	base := slices.Clone(args)
+	// keyset добавляет условие курсора к списку аргументов a
+	keyset := func(key *commentKey, after bool, a []any) (string, []any) {
+		cond := commentKeyset(*key, after, func(v any) string {
+			a = append(a, v)
+			return "$" + strconv.Itoa(len(a))
+		})
+		return cond, a
 	}
 
-	if after != nil && *after != "" {
-		if ts, id, ok := decodeCursor(*after); ok {
-			placeholderTs := len(args) + 1
-			placeholderId := len(args) + 2
-			where += " and (c.created_at > $" + strconv.Itoa(placeholderTs) + " or (c.created_at = $" + strconv.Itoa(placeholderTs) + " and c.id > $" + strconv.Itoa(placeholderId) + "))"
-			args = append(args, ts, id)
+	var afterKey, beforeKey *commentKey
+	if cq.After != nil && *cq.After != "" {
+		key, err := decodeCommentKey(*cq.After, cq.Order)
+		if err != nil {
+			return nil, err
+		}
+		afterKey = &key
+	}
+	if cq.Before != nil && *cq.Before != "" {
+		key, err := decodeCommentKey(*cq.Before, cq.Order)
+		if err != nil {
+			return nil, err
 		}
+		beforeKey = &key
 	}
 
-	q := fmt.Sprintf(`
-    select c.id, c.post_id, c.parent_id, c.body, c.author, c.depth, c.created_at
-    from comments c where %s order by c.created_at asc, c.id asc limit %d`,
-		where, limit)
+	conds := []string{"true"}
+	if afterKey != nil {
+		var cond string
+		cond, args = keyset(afterKey, true, args)
+		conds = append(conds, cond)
+	}
+	if beforeKey != nil {
+		var cond string
+		cond, args = keyset(beforeKey, false, args)
+		conds = append(conds, cond)
+	}
+
+	orderBy := commentOrderBy(cq.Order, cq.Backward)
+	// берём на одну строку больше, чтобы честно узнать, есть ли ещё страница
+	q := fmt.Sprintf(`select %s, score, path from %s where %s order by %s limit %d`,
+		commentColumns, src, strings.Join(conds, " and "), orderBy, cq.Limit+1)
 
 	rows, err := p.db.QueryContext(ctx, q, args...)
 	if err != nil {
@@ -174,84 +583,912 @@ func (p *PostgresStore) ListComments(ctx context.Context, postID string, parentI
 	}
 	defer rows.Close()
 
-	var items []*model.Comment
+	edges := []*model.CommentEdge{}
 	for rows.Next() {
-		var cm model.Comment
-		if err := rows.Scan(&cm.ID, &cm.PostID, &cm.ParentID, &cm.Body, &cm.Author, &cm.Depth, &cm.CreatedAt); err != nil {
+		key := commentKey{order: cq.Order}
+		cm, err := scanComment(rows, &key.score, &key.path)
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, &cm)
+		key.at, key.id = cm.CreatedAt, cm.ID
+		edges = append(edges, &model.CommentEdge{Cursor: key.encode(), Node: hideDeleted(cm)})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	edges := make([]*model.CommentEdge, 0, len(items))
-	for _, it := range items {
-		cur := encodeCursor(it.CreatedAt, it.ID)
-		edges = append(edges, &model.CommentEdge{Cursor: cur, Node: it})
+	more := len(edges) > cq.Limit
+	if more {
+		edges = edges[:cq.Limit]
+	}
+	if cq.Backward {
+		slices.Reverse(edges)
+	}
+
+	// страница с другой стороны курсора есть, если какие-то строки не проходят его условие
+	exists := func(key *commentKey, after bool) (bool, error) {
+		if key == nil {
+			return false, nil
+		}
+		cond, a := keyset(key, after, base)
+		var ok bool
+		err := p.db.QueryRowContext(ctx, `select exists (select 1 from `+src+` where not (`+cond+`))`, a...).Scan(&ok)
+		return ok, err
 	}
 
-	pageInfo := &model.PageInfo{HasNextPage: len(items) == limit}
+	pageInfo := &model.PageInfo{}
+	if cq.Backward {
+		pageInfo.HasPreviousPage = more
+		if pageInfo.HasNextPage, err = exists(beforeKey, false); err != nil {
+			return nil, err
+		}
+	} else {
+		pageInfo.HasNextPage = more
+		if pageInfo.HasPreviousPage, err = exists(afterKey, true); err != nil {
+			return nil, err
+		}
+	}
 	if len(edges) > 0 {
-		end := edges[len(edges)-1].Cursor
-		pageInfo.EndCursor = &end
+		start, end := edges[0].Cursor, edges[len(edges)-1].Cursor
+		pageInfo.StartCursor, pageInfo.EndCursor = &start, &end
+	}
+
+	var total int
+	if err := p.db.QueryRowContext(ctx, `select count(*) from `+src, base...).Scan(&total); err != nil {
+		return nil, err
 	}
 
-	return &model.CommentPage{Edges: edges, PageInfo: pageInfo}, nil
+	return &model.CommentPage{Edges: edges, PageInfo: pageInfo, TotalCount: total}, nil
 }
 
-func encodeCursor(ts time.Time, id string) string {
-	raw := ts.Format(time.RFC3339Nano) + ":" + id
-	return base64.StdEncoding.EncodeToString([]byte(raw))
+// commentKeyset возвращает условие «строка после key» (after) или «строка перед key» в порядке key.order.
+func commentKeyset(key commentKey, after bool, arg func(any) string) string {
+	gt, lt := ">", "<"
+	if !after {
+		gt, lt = lt, gt
+	}
+
+	switch key.order {
+	case model.CommentOrderThreaded:
+		return `path ` + gt + ` ` + arg(key.path)
+	case model.CommentOrderNewest:
+		return `(created_at, id) ` + lt + ` (` + arg(key.at) + `, ` + arg(key.id) + `)`
+	case model.CommentOrderTop:
+		s := arg(key.score)
+		return `(score ` + lt + ` ` + s + ` or (score = ` + s + ` and (created_at, id) ` + gt + ` (` + arg(key.at) + `, ` + arg(key.id) + `)))`
+	default:
+		return `(created_at, id) ` + gt + ` (` + arg(key.at) + `, ` + arg(key.id) + `)`
+	}
 }
 
-func decodeCursor(cursor string) (time.Time, string, bool) {
-	decoded, err := base64.StdEncoding.DecodeString(cursor)
+func commentOrderBy(order model.CommentOrder, backward bool) string {
+	asc, desc := "asc", "desc"
+	if backward {
+		asc, desc = desc, asc
+	}
+
+	switch order {
+	case model.CommentOrderThreaded:
+		return `path ` + asc
+	case model.CommentOrderNewest:
+		return `created_at ` + desc + `, id ` + desc
+	case model.CommentOrderTop:
+		return `score ` + desc + `, created_at ` + asc + `, id ` + asc
+	default:
+		return `created_at ` + asc + `, id ` + asc
+	}
+}
+
+func (p *PostgresStore) UpdateCommentBody(ctx context.Context, id string, body string, editedAt time.Time, expectedVersion *int) (*model.Comment, error) {
+	tx, err := p.begin(ctx)
 	if err != nil {
-		return time.Time{}, "", false
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	parts := strings.SplitN(string(decoded), ":", 2)
-	if len(parts) != 2 {
-		return time.Time{}, "", false
+	const getPrev = `select body, coalesce(edited_at, created_at), version from comments where id = $1 for update`
+	var prevBody string
+	var prevAt time.Time
+	var version int
+	if err := tx.QueryRowContext(ctx, getPrev, id).Scan(&prevBody, &prevAt, &version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if err := checkVersion(expectedVersion, version); err != nil {
+		return nil, err
+	}
+
+	const saveRev = `insert into comment_revisions (comment_id, body, created_at) values ($1, $2, $3)`
+	if _, err := tx.ExecContext(ctx, saveRev, id, prevBody, prevAt); err != nil {
+		return nil, err
 	}
 
-	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	q := `update comments set body = $2, edited_at = $3, version = version + 1 where id = $1 returning ` + commentColumns
+	c, err := scanComment(tx.QueryRowContext(ctx, q, id, body, editedAt))
 	if err != nil {
-		return time.Time{}, "", false
+		return nil, err
 	}
 
-	return ts, parts[1], true
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-func (p *PostgresStore) BatchCommentsCount(ctx context.Context, postIDs []string) (map[string]int, error) {
-	if len(postIDs) == 0 {
-		return map[string]int{}, nil
+func (p *PostgresStore) DeleteComment(ctx context.Context, id string, deletedAt time.Time, expectedVersion *int) (*model.Comment, error) {
+	// повторное удаление не сдвигает deleted_at и не уменьшает comments_count второй раз; prev блокирует строку,
+	// чтобы параллельное удаление увидело уже удалённый комментарий
+	q := `
+    with prev as (
+        select id, deleted_at is null as live from comments where id = $1 for update
+    ), del as (
+        update comments c set deleted_at = coalesce(c.deleted_at, $2), version = c.version + 1
+        from prev where c.id = prev.id and ($3::int is null or c.version = $3)
+        returning c.*, prev.live
+    ), cnt as (
+        update posts set comments_count = comments_count - 1 from del where posts.id = del.post_id and del.live
+    )
+    select ` + commentColumns + ` from del`
+
+	c, err := scanComment(p.db.QueryRowContext(ctx, q, id, deletedAt, expectedVersion))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, p.versionMiss(ctx, "comments", id, expectedVersion)
+		}
+		return nil, err
 	}
+	return hideDeleted(c), nil
+}
 
-	const q = `select post_id, count(*) from comments where post_id = any($1) group by post_id`
-	rows, err := p.db.QueryContext(ctx, q, pgArray(postIDs))
+func (p *PostgresStore) LockComment(ctx context.Context, id string, locked bool, expectedVersion *int) (*model.Comment, error) {
+	q := `update comments set locked = $2, version = version + 1
+    where id = $1 and ($3::int is null or version = $3) returning ` + commentColumns
+
+	c, err := scanComment(p.db.QueryRowContext(ctx, q, id, locked, expectedVersion))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, p.versionMiss(ctx, "comments", id, expectedVersion)
+		}
 		return nil, err
 	}
-	defer rows.Close()
+	return hideDeleted(c), nil
+}
 
-	out := make(map[string]int, len(postIDs))
-	for _, id := range postIDs {
-		out[id] = 0
+func (p *PostgresStore) ListCommentRevisions(ctx context.Context, commentID string) ([]*model.CommentRevision, error) {
+	const q = `select body, created_at from comment_revisions where comment_id = $1 order by created_at asc, id asc`
+
+	rows, err := p.db.QueryContext(ctx, q, commentID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
+	res := []*model.CommentRevision{}
 	for rows.Next() {
-		var pid string
-		var cnt int
-		if err := rows.Scan(&pid, &cnt); err != nil {
+		var rev model.CommentRevision
+		if err := rows.Scan(&rev.Body, &rev.CreatedAt); err != nil {
 			return nil, err
 		}
-		out[pid] = cnt
+		res = append(res, &rev)
+	}
+	return res, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const postColumns = `id, title, body, author, comments_closed, created_at, comments_count, status, publish_at, version`
+
+// postFeedTime — feedTime в SQL; по этому выражению построены индексы ленты (миграция 0019).
+const postFeedTime = `coalesce(publish_at, created_at)`
+
+func scanPost(row rowScanner) (*model.Post, error) {
+	var p model.Post
+	if err := row.Scan(&p.ID, &p.Title, &p.Body, &p.Author, &p.CommentsClosed, &p.CreatedAt, &p.CommentsCount,
+		&p.Status, &p.PublishAt, &p.Version); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+const commentColumns = `id, post_id, parent_id, reply_to_id, author, body, depth, created_at, edited_at, deleted_at, locked, version`
+
+// scanComment читает commentColumns и, если переданы, следующие за ними колонки в extra.
+func scanComment(row rowScanner, extra ...any) (*model.Comment, error) {
+	var c model.Comment
+	dest := append([]any{&c.ID, &c.PostID, &c.ParentID, &c.ReplyToID, &c.Author, &c.Body, &c.Depth, &c.CreatedAt, &c.EditedAt, &c.DeletedAt, &c.Locked, &c.Version}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	c.Deleted = c.DeletedAt != nil
+	return &c, nil
+}
+
+func (p *PostgresStore) Search(ctx context.Context, query string, after *string, limit int) (*model.SearchPage, error) {
+	offset := 0
+	if after != nil && *after != "" {
+		var ok bool
+		if offset, ok = decodeOffsetCursor(*after); !ok {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	// ts_headline дорогой, поэтому считаем его только для строк текущей страницы. Совпадения он размечает
+	// управляющими символами, а не тегами: текст экранируется уже в Go, см. pgSnippet
+	const q = `
+    with q as (select plainto_tsquery('simple', $1) as query)
+    select hits.kind, hits.id, hits.score,
+        ts_headline('simple', translate(hits.doc, chr(2) || chr(3), ''), q.query, 'StartSel=' || chr(2) || ', StopSel=' || chr(3))
+    from (
+        select 'POST' as kind, p.id, ts_rank(p.search_vector, q.query) as score, p.created_at, p.title || ' ' || p.body as doc
+        from posts p, q where p.search_vector @@ q.query and p.status = 'PUBLISHED'
+        union all
+        select 'COMMENT', c.id, ts_rank(c.search_vector, q.query), c.created_at, c.body
+        from comments c, q where c.search_vector @@ q.query and c.deleted_at is null
+        order by score desc, kind asc, id asc
+        limit $2 offset $3
+    ) hits, q
+    order by hits.score desc, hits.kind asc, hits.id asc`
+
+	rows, err := p.db.QueryContext(ctx, q, query, limit+1, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []*model.SearchHit
+	var postIDs, commentIDs []string
+	for rows.Next() {
+		var hit model.SearchHit
+		var id string
+		if err := rows.Scan(&hit.Kind, &id, &hit.Score, &hit.Snippet); err != nil {
+			return nil, err
+		}
+		hit.Snippet = pgSnippet(hit.Snippet)
+		if hit.Kind == model.SearchHitKindPost {
+			hit.Post = &model.Post{ID: id}
+			postIDs = append(postIDs, id)
+		} else {
+			hit.Comment = &model.Comment{ID: id}
+			commentIDs = append(commentIDs, id)
+		}
+		hits = append(hits, &hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	hasNext := len(hits) > limit
+	if hasNext {
+		hits = hits[:limit]
+	}
+
+	comments, err := p.GetComments(ctx, commentIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range comments {
+		postIDs = append(postIDs, c.PostID)
+	}
+	posts, err := p.GetPosts(ctx, postIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := make([]*model.SearchEdge, 0, len(hits))
+	for i, hit := range hits {
+		if hit.Comment != nil {
+			hit.Comment = comments[hit.Comment.ID]
+			hit.Post = posts[hit.Comment.PostID]
+		} else {
+			hit.Post = posts[hit.Post.ID]
+		}
+		edges = append(edges, &model.SearchEdge{Cursor: encodeOffsetCursor(offset + i + 1), Node: hit})
+	}
+
+	pageInfo := &model.PageInfo{HasNextPage: hasNext, HasPreviousPage: offset > 0}
+	if len(edges) > 0 {
+		start, end := edges[0].Cursor, edges[len(edges)-1].Cursor
+		pageInfo.StartCursor, pageInfo.EndCursor = &start, &end
+	}
+
+	return &model.SearchPage{Edges: edges, PageInfo: pageInfo}, nil
+}
+
+func (p *PostgresStore) React(ctx context.Context, postID string, commentID *string, user string, kind model.ReactionKind, at time.Time) error {
+	target := postID
+	if commentID != nil {
+		target = *commentID
+	}
+
+	const q = `insert into reactions (target_id, post_id, comment_id, user_name, kind, created_at)
+	values ($1, $2, $3, $4, $5, $6) on conflict do nothing`
+
+	_, err := p.db.ExecContext(ctx, q, target, postID, commentID, user, string(kind), at)
+	return err
+}
+
+func (p *PostgresStore) Unreact(ctx context.Context, targetID string, user string, kind model.ReactionKind) error {
+	const q = `delete from reactions where target_id = $1 and user_name = $2 and kind = $3`
+
+	_, err := p.db.ExecContext(ctx, q, targetID, user, string(kind))
+	return err
+}
+
+func (p *PostgresStore) BatchReactions(ctx context.Context, targetIDs []string, viewer string) (map[string][]*model.ReactionCount, error) {
+	out := make(map[string][]*model.ReactionCount, len(targetIDs))
+	for _, id := range targetIDs {
+		out[id] = []*model.ReactionCount{}
+	}
+	if len(targetIDs) == 0 {
+		return out, nil
+	}
+
+	const q = `select target_id, kind, count(*), bool_or(user_name = $2)
+	from reactions where target_id = any($1) group by target_id, kind`
+
+	rows, err := p.db.QueryContext(ctx, q, pgArray(targetIDs), viewer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target string
+		var rc model.ReactionCount
+		if err := rows.Scan(&target, &rc.Kind, &rc.Count, &rc.ViewerHasReacted); err != nil {
+			return nil, err
+		}
+		out[target] = append(out[target], &rc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, counts := range out {
+		sortReactionCounts(counts)
+	}
+	return out, nil
+}
+
+func (p *PostgresStore) GetPosts(ctx context.Context, ids []string) (map[string]*model.Post, error) {
+	out := make(map[string]*model.Post, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	q := `select ` + postColumns + ` from posts where id = any($1)`
+	rows, err := p.db.QueryContext(ctx, q, pgArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[post.ID] = post
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) GetUsers(ctx context.Context, ids []string) (map[string]*model.User, error) {
+	out := make(map[string]*model.User, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	const q = `select ` + userColumns + ` from users where id = any($1)`
+	rows, err := p.db.QueryContext(ctx, q, pgArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[u.ID] = u
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) EnsureUser(ctx context.Context, name string, at time.Time) (*model.User, error) {
+	if err := ensureUser(ctx, p.db, name, at); err != nil {
+		return nil, err
+	}
+	const q = `select ` + userColumns + ` from users where id = $1`
+	return scanUser(p.db.QueryRowContext(ctx, q, UserID(name)))
+}
+
+func (p *PostgresStore) SetUserRole(ctx context.Context, name string, role model.Role, at time.Time) (*model.User, error) {
+	const q = `insert into users (id, name, created_at, role) values ($1, $2, $3, $4)
+    on conflict (id) do update set role = excluded.role
+    returning ` + userColumns
+	return scanUser(p.db.QueryRowContext(ctx, q, UserID(name), name, at, string(role)))
+}
+
+const userColumns = `id, name, role, created_at`
+
+func scanUser(row rowScanner) (*model.User, error) {
+	var u model.User
+	if err := row.Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+const apiTokenColumns = `id, user_name, name, token_hash, array_to_string(scopes, ' '), created_at, expires_at, revoked_at`
+
+func scanAPIToken(row interface{ Scan(...any) error }) (*model.APIToken, error) {
+	var t model.APIToken
+	var scopes string
+	if err := row.Scan(&t.ID, &t.User, &t.Name, &t.Hash, &scopes, &t.CreatedAt, &t.ExpiresAt, &t.RevokedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	return &t, nil
+}
+
+func (p *PostgresStore) CreateAPIToken(ctx context.Context, t *model.APIToken) error {
+	const q = `insert into api_tokens (id, user_name, name, token_hash, scopes, created_at, expires_at)
+	values ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := p.db.ExecContext(ctx, q, t.ID, t.User, t.Name, t.Hash, pgArray(t.Scopes), t.CreatedAt, t.ExpiresAt)
+	return err
+}
+
+func (p *PostgresStore) GetAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error) {
+	q := `select ` + apiTokenColumns + ` from api_tokens where token_hash = $1`
+	t, err := scanAPIToken(p.db.QueryRowContext(ctx, q, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+func (p *PostgresStore) ListAPITokens(ctx context.Context, user string) ([]*model.APIToken, error) {
+	q := `select ` + apiTokenColumns + ` from api_tokens where user_name = $1 order by created_at desc, id desc`
+	rows, err := p.db.QueryContext(ctx, q, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*model.APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) RevokeAPIToken(ctx context.Context, user string, id string, at time.Time) (*model.APIToken, error) {
+	q := `update api_tokens set revoked_at = coalesce(revoked_at, $3) where id = $1 and user_name = $2
+	returning ` + apiTokenColumns
+	t, err := scanAPIToken(p.db.QueryRowContext(ctx, q, id, user, at))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return t, err
+}
+
+func (p *PostgresStore) GetComments(ctx context.Context, ids []string) (map[string]*model.Comment, error) {
+	out := make(map[string]*model.Comment, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	q := `select ` + commentColumns + ` from comments where id = any($1)`
+	rows, err := p.db.QueryContext(ctx, q, pgArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[c.ID] = hideDeleted(c)
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) CommentTree(ctx context.Context, postID string, rootID *string, maxDepth int, maxNodes int) ([]*model.CommentTreeNode, error) {
+	const q = `
+    with recursive tree as (
+        select id, post_id, parent_id, reply_to_id, author, body, depth, created_at, edited_at, deleted_at, locked, version, 0 as lvl
+        from comments
+        where post_id = $1 and (case when $2::uuid is null then parent_id is null else id = $2::uuid end)
+        union all
+        select c.id, c.post_id, c.parent_id, c.reply_to_id, c.author, c.body, c.depth, c.created_at, c.edited_at, c.deleted_at, c.locked, c.version, t.lvl + 1
+        from comments c join tree t on c.parent_id = t.id
+        where t.lvl < $3
+    )
+    select ` + commentColumns + `, lvl, (select count(*) from comments r where r.parent_id = tree.id)
+    from tree order by lvl, created_at, id limit $4`
+
+	rows, err := p.db.QueryContext(ctx, q, postID, rootID, maxDepth, maxNodes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flat []*model.Comment
+	roots := map[string]bool{}
+	replies := map[string]int{}
+	for rows.Next() {
+		var lvl, cnt int
+		c, err := scanComment(rows, &lvl, &cnt)
+		if err != nil {
+			return nil, err
+		}
+		if lvl == 0 {
+			roots[c.ID] = true
+		}
+		replies[c.ID] = cnt
+		flat = append(flat, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if rootID != nil && len(flat) == 0 {
+		return nil, ErrNotFound
+	}
+	return buildCommentTree(flat, roots, replies), nil
+}
+
+func encodeCursor(ts time.Time, id string) string {
+	raw := ts.Format(time.RFC3339Nano) + ":" + id
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, string, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	// в RFC3339 тоже есть двоеточия, поэтому id отделяем по последнему
+	raw := string(decoded)
+	sep := strings.LastIndex(raw, ":")
+	if sep < 0 {
+		return time.Time{}, "", false
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, raw[:sep])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	return ts, raw[sep+1:], true
+}
+
+func (p *PostgresStore) BatchCommentsCount(ctx context.Context, postIDs []string) (map[string]int, error) {
+	if len(postIDs) == 0 {
+		return map[string]int{}, nil
+	}
+
+	const q = `select id, comments_count from posts where id = any($1)`
+	rows, err := p.db.QueryContext(ctx, q, pgArray(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]int, len(postIDs))
+	for _, id := range postIDs {
+		out[id] = 0
+	}
+
+	for rows.Next() {
+		var pid string
+		var cnt int
+		if err := rows.Scan(&pid, &cnt); err != nil {
+			return nil, err
+		}
+		out[pid] = cnt
 	}
 	return out, rows.Err()
 }
 
 // обертка для pgx
 func pgArray(ss []string) any { return ss }
+
+// exportBatch — сколько постов выгрузки за раз дополняется тегами.
+const exportBatch = 500
+
+// Export читает все таблицы из одного снимка, иначе комментарий, добавленный во время выгрузки, мог бы попасть
+// в неё без своего поста.
+func (p *PostgresStore) Export(ctx context.Context, fn func(Record) error) error {
+	if p.tx != nil {
+		return p.export(ctx, fn)
+	}
+
+	tx, err := p.pool.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := (&PostgresStore{pool: p.pool, db: tx, tx: tx}).export(ctx, fn); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *PostgresStore) export(ctx context.Context, fn func(Record) error) error {
+	rows, err := p.db.QueryContext(ctx, `select `+postColumns+` from posts order by created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	batch := make([]*model.Post, 0, exportBatch)
+	flush := func() error {
+		ids := make([]string, len(batch))
+		for i, post := range batch {
+			ids[i] = post.ID
+		}
+		tags, err := p.BatchPostTags(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, post := range batch {
+			if err := fn(Record{Type: RecordPost, Post: post, Tags: tags[post.ID]}); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, post)
+		if len(batch) == exportBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	// по глубине: родитель всегда на уровень выше ответа
+	rows, err = p.db.QueryContext(ctx, `select `+commentColumns+` from comments order by depth, created_at, id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(Record{Type: RecordComment, Comment: hideDeleted(c)}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import вставляет батч одним запросом на таблицу через jsonb_to_recordset в одной транзакции.
+func (p *PostgresStore) Import(ctx context.Context, records []Record) (int, error) {
+	posts, comments, err := importBatch(records)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	added := 0
+	if len(posts) > 0 {
+		type postRow struct {
+			*model.Post
+			Tags []string `json:"tags"`
+		}
+		batch := make([]postRow, len(posts))
+		for i, rec := range posts {
+			post := *rec.Post
+			defaultPostStatus(&post)
+			batch[i] = postRow{Post: &post, Tags: rec.Tags}
+		}
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return 0, err
+		}
+
+		const insertPosts = `
+        insert into posts (id, title, body, author, comments_closed, created_at, status, publish_at, version)
+        select id, title, body, author, "commentsClosed", "createdAt", status, "publishAt", greatest(version, 1)
+        from jsonb_to_recordset($1::jsonb) as x(id uuid, title text, body text, author text, "commentsClosed" boolean,
+            "createdAt" timestamptz, status text, "publishAt" timestamptz, version int)
+        on conflict (id) do nothing`
+		res, err := tx.ExecContext(ctx, insertPosts, string(data))
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		added += int(n)
+
+		const insertTags = `
+        with x as (
+            select r.id as post_id, unnest(r.tags) as name
+            from jsonb_to_recordset($1::jsonb) as r(id uuid, tags text[])
+        ), t as (
+            insert into tags (name) select distinct name from x on conflict (name) do nothing
+        )
+        insert into post_tags (post_id, tag_id)
+        select x.post_id, tags.id from x join tags on tags.name = x.name
+        on conflict do nothing`
+		if _, err := tx.ExecContext(ctx, insertTags, string(data)); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(posts) > 0 || len(comments) > 0 {
+		if err := importUsers(ctx, tx, posts, comments); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(comments) > 0 {
+		data, err := json.Marshal(comments)
+		if err != nil {
+			return 0, err
+		}
+
+		// comments_count растёт только на действительно вставленные и неудалённые строки
+		const insertComments = `
+        with ins as (
+            insert into comments (id, post_id, parent_id, reply_to_id, body, author, depth, created_at, edited_at, deleted_at, locked, version)
+            select id, "postID", "parentID", "replyToID", body, author, depth, "createdAt", "editedAt",
+                coalesce("deletedAt", case when deleted then "createdAt" end), coalesce(locked, false), greatest(version, 1)
+            from jsonb_to_recordset($1::jsonb) as x(id uuid, "postID" uuid, "parentID" uuid, "replyToID" uuid,
+                body text, author text, depth int, "createdAt" timestamptz, "editedAt" timestamptz, deleted boolean,
+                "deletedAt" timestamptz, locked boolean, version int)
+            on conflict (id) do nothing
+            returning post_id, deleted_at
+        ), cnt as (
+            select post_id, count(*) as n, count(*) filter (where deleted_at is null) as live from ins group by post_id
+        ), upd as (
+            update posts set comments_count = comments_count + cnt.live from cnt where posts.id = cnt.post_id
+        )
+        select coalesce(sum(n), 0) from cnt`
+		var n int
+		if err := tx.QueryRowContext(ctx, insertComments, string(data)).Scan(&n); err != nil {
+			return 0, err
+		}
+		added += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// importUsers заводит авторов импортируемых записей, которых ещё нет.
+func importUsers(ctx context.Context, db dbtx, posts []Record, comments []*model.Comment) error {
+	type userRow struct {
+		ID        string    `json:"id"`
+		Name      string    `json:"name"`
+		CreatedAt time.Time `json:"createdAt"`
+	}
+	seen := map[string]int{}
+	var batch []userRow
+	add := func(name string, at time.Time) {
+		if name == "" {
+			return
+		}
+		if i, ok := seen[name]; ok {
+			if at.Before(batch[i].CreatedAt) {
+				batch[i].CreatedAt = at
+			}
+			return
+		}
+		seen[name] = len(batch)
+		batch = append(batch, userRow{ID: UserID(name), Name: name, CreatedAt: at})
+	}
+	for _, rec := range posts {
+		add(rec.Post.Author, rec.Post.CreatedAt)
+	}
+	for _, c := range comments {
+		add(c.Author, c.CreatedAt)
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	const q = `insert into users (id, name, created_at)
+    select id, name, "createdAt" from jsonb_to_recordset($1::jsonb) as x(id uuid, name text, "createdAt" timestamptz)
+    on conflict do nothing`
+	_, err = db.ExecContext(ctx, q, string(data))
+	return err
+}
+
+func (p *PostgresStore) ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (*IdempotencyKey, error) {
+	// при конфликте insert ждёт транзакцию, которая заняла ключ; истёкший или брошенный ключ занимаем заново
+	const claim = `insert into idempotency_keys (user_name, key, op, created_at) values ($1, $2, $3, $4)
+    on conflict (user_name, key) do update set op = excluded.op, result = null, created_at = excluded.created_at
+    where idempotency_keys.created_at < $5 or idempotency_keys.result is null`
+	res, err := p.db.ExecContext(ctx, claim, k.User, k.Key, k.Op, k.CreatedAt, since)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		return nil, nil
+	}
+
+	const q = `select user_name, key, op, result, created_at from idempotency_keys where user_name = $1 and key = $2`
+	var cur IdempotencyKey
+	var result []byte
+	if err := p.db.QueryRowContext(ctx, q, k.User, k.Key).Scan(&cur.User, &cur.Key, &cur.Op, &result, &cur.CreatedAt); err != nil {
+		return nil, err
+	}
+	cur.Result = result
+	return &cur, nil
+}
+
+func (p *PostgresStore) SetIdempotencyResult(ctx context.Context, user string, key string, result []byte) error {
+	const q = `update idempotency_keys set result = $3 where user_name = $1 and key = $2`
+
+	res, err := p.db.ExecContext(ctx, q, user, key, string(result))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int, error) {
+	res, err := p.db.ExecContext(ctx, `delete from idempotency_keys where created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
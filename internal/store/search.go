@@ -0,0 +1,229 @@
+package store
+
+import (
+	"encoding/base64"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
+)
+
+// Веса полей повторяют веса ts_rank по умолчанию для меток A, B и D.
+const (
+	weightTitle       = 1.0
+	weightPostBody    = 0.4
+	weightCommentBody = 0.1
+
+	snippetWords = 35
+	highlightOn  = "<b>"
+	highlightOff = "</b>"
+
+	// pgHighlightOn и pgHighlightOff — метки совпадений от ts_headline; в тексте их нет (вырезаются в запросе),
+	// поэтому после экранирования их можно заменить на теги без риска принять за них пользовательский текст.
+	pgHighlightOn  = "\x02"
+	pgHighlightOff = "\x03"
+)
+
+type docKey struct {
+	kind model.SearchHitKind
+	id   string
+}
+
+// searchIndex — инвертированный индекс MemStore: токен -> документ -> взвешенная частота.
+// Не потокобезопасен, защищается мьютексом MemStore.
+type searchIndex struct {
+	terms map[string]map[docKey]float64
+	docs  map[docKey][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		terms: map[string]map[docKey]float64{},
+		docs:  map[docKey][]string{},
+	}
+}
+
+func (ix *searchIndex) indexPost(p *model.Post) {
+	key := docKey{kind: model.SearchHitKindPost, id: p.ID}
+	ix.remove(key)
+	// неопубликованные посты ищутся только после публикации
+	if p.Status != model.PostStatusPublished {
+		return
+	}
+
+	weights := map[string]float64{}
+	for _, tok := range tokenize(p.Title) {
+		weights[tok] += weightTitle
+	}
+	for _, tok := range tokenize(p.Body) {
+		weights[tok] += weightPostBody
+	}
+	ix.add(key, weights)
+}
+
+func (ix *searchIndex) indexComment(c *model.Comment) {
+	key := docKey{kind: model.SearchHitKindComment, id: c.ID}
+	ix.remove(key)
+	if c.Deleted {
+		return
+	}
+
+	weights := map[string]float64{}
+	for _, tok := range tokenize(c.Body) {
+		weights[tok] += weightCommentBody
+	}
+	ix.add(key, weights)
+}
+
+func (ix *searchIndex) removePost(id string) {
+	ix.remove(docKey{kind: model.SearchHitKindPost, id: id})
+}
+
+func (ix *searchIndex) removeComment(id string) {
+	ix.remove(docKey{kind: model.SearchHitKindComment, id: id})
+}
+
+func (ix *searchIndex) add(key docKey, weights map[string]float64) {
+	toks := make([]string, 0, len(weights))
+	for tok, w := range weights {
+		postings := ix.terms[tok]
+		if postings == nil {
+			postings = map[docKey]float64{}
+			ix.terms[tok] = postings
+		}
+		postings[key] = w
+		toks = append(toks, tok)
+	}
+	ix.docs[key] = toks
+}
+
+func (ix *searchIndex) remove(key docKey) {
+	for _, tok := range ix.docs[key] {
+		postings := ix.terms[tok]
+		delete(postings, key)
+		if len(postings) == 0 {
+			delete(ix.terms, tok)
+		}
+	}
+	delete(ix.docs, key)
+}
+
+type scoredDoc struct {
+	key   docKey
+	score float64
+}
+
+// match возвращает документы, содержащие все токены запроса (как plainto_tsquery), по убыванию веса.
+func (ix *searchIndex) match(query []string) []scoredDoc {
+	if len(query) == 0 {
+		return nil
+	}
+
+	var res []scoredDoc
+	for key, w := range ix.terms[query[0]] {
+		score := w
+		ok := true
+		for _, tok := range query[1:] {
+			tw, found := ix.terms[tok][key]
+			if !found {
+				ok = false
+				break
+			}
+			score += tw
+		}
+		if ok {
+			// длинные документы не должны выигрывать только за счёт объёма
+			score /= 1 + math.Log(float64(1+len(ix.docs[key])))
+			res = append(res, scoredDoc{key: key, score: score})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		if res[i].key.kind != res[j].key.kind {
+			return res[i].key.kind < res[j].key.kind
+		}
+		return res[i].key.id < res[j].key.id
+	})
+	return res
+}
+
+// tokenize разбивает текст на слова в нижнем регистре, как конфигурация 'simple' в Postgres.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isNotWordRune)
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlight вырезает фрагмент вокруг первого совпадения и оборачивает совпавшие слова в <b></b>.
+// Фрагмент отдаётся как HTML, поэтому весь текст вокруг тегов экранируется.
+func highlight(text string, query []string) string {
+	want := make(map[string]struct{}, len(query))
+	for _, q := range query {
+		want[q] = struct{}{}
+	}
+
+	words := strings.Fields(text)
+	first := -1
+	for i, w := range words {
+		if _, ok := want[strings.ToLower(strings.TrimFunc(w, isNotWordRune))]; ok {
+			first = i
+			break
+		}
+	}
+
+	start := 0
+	if first > snippetWords/3 {
+		start = first - snippetWords/3
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+
+	out := make([]string, 0, end-start)
+	for _, w := range words[start:end] {
+		core := strings.TrimFunc(w, isNotWordRune)
+		if _, ok := want[strings.ToLower(core)]; ok && core != "" {
+			before, after, _ := strings.Cut(w, core)
+			w = html.EscapeString(before) + highlightOn + html.EscapeString(core) + highlightOff + html.EscapeString(after)
+		} else {
+			w = html.EscapeString(w)
+		}
+		out = append(out, w)
+	}
+	return strings.Join(out, " ")
+}
+
+// pgSnippet экранирует фрагмент от ts_headline и заменяет его метки совпадений на <b></b>.
+func pgSnippet(s string) string {
+	return strings.NewReplacer(pgHighlightOn, highlightOn, pgHighlightOff, highlightOff).Replace(html.EscapeString(s))
+}
+
+func encodeOffsetCursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+func decodeOffsetCursor(cursor string) (int, bool) {
+	decoded, err := base64.StdEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, false
+	}
+	raw, ok := strings.CutPrefix(string(decoded), "offset:")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
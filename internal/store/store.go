@@ -3,22 +3,225 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"time"
 
 	"github.com/bilyardvmetro/ozon-Posts-And-Comments-test-project/graph/model"
 )
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// ConflictError — запись пришла с expectedVersion, а версия поста или комментария уже другая.
+type ConflictError struct {
+	Expected int
+	Actual   int
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("version conflict: expected %d, current %d", e.Expected, e.Actual)
+}
+
+// checkVersion сравнивает текущую версию с ожидаемой; nil expected — без проверки.
+func checkVersion(expected *int, actual int) error {
+	if expected != nil && *expected != actual {
+		return &ConflictError{Expected: *expected, Actual: actual}
+	}
+	return nil
+}
 
+// PostFilter сужает выборку постов; nil-поля не учитываются.
+// Since и Until сравниваются с временем поста в ленте (feedTime); Since включительно, Until — нет.
+type PostFilter struct {
+	Author         *string
+	Since          *time.Time
+	Until          *time.Time
+	CommentsClosed *bool
+	Tag            *string // уже нормализованный
+	// Viewer видит свои черновики и отложенные посты; остальным доступны только опубликованные.
+	Viewer *string
+}
+
+// CommentsQuery описывает страницу комментариев поста.
+// Без ParentID берутся все комментарии поста, с ним — прямые ответы (в THREADED — вся ветка под ним).
+// Backward берёт Limit комментариев перед Before (или последние), иначе — Limit после After.
+type CommentsQuery struct {
+	PostID   string
+	ParentID *string
+	Order    model.CommentOrder
+	After    *string
+	Before   *string
+	Limit    int
+	Backward bool
+}
+
+// Методы, принимающие expectedVersion, ничего не меняют и возвращают *ConflictError, если версия записи
+// отличается от ожидаемой; nil — без проверки. Каждая успешная правка поднимает версию на единицу.
 type Store interface {
 	// Posts
 	CreatePost(ctx context.Context, post *model.Post) error
 	GetPost(ctx context.Context, id string) (*model.Post, error)
-	ListPosts(ctx context.Context) ([]*model.Post, error)
-	CloseComments(ctx context.Context, id string, closed bool) (*model.Post, error)
+	// GetPosts — пакетный GetPost: найденные посты по id, отсутствующих в карте нет.
+	GetPosts(ctx context.Context, ids []string) (map[string]*model.Post, error)
+	ListPosts(ctx context.Context, filter PostFilter, after *string, limit int) (*model.PostPage, error)
+	CloseComments(ctx context.Context, id string, closed bool, expectedVersion *int) (*model.Post, error)
+	// UpdatePost меняет заголовок и/или текст поста; nil-поля не трогаются.
+	UpdatePost(ctx context.Context, id string, title *string, body *string, expectedVersion *int) (*model.Post, error)
+	// DeletePost удаляет пост вместе со всеми его комментариями.
+	DeletePost(ctx context.Context, id string, expectedVersion *int) error
+	// SetPostStatus переводит пост в status; publishAt — запланированное или фактическое время публикации.
+	SetPostStatus(ctx context.Context, id string, status model.PostStatus, publishAt *time.Time, expectedVersion *int) (*model.Post, error)
+	// PublishDue публикует отложенные посты, время которых наступило к now, и возвращает их.
+	PublishDue(ctx context.Context, now time.Time) ([]*model.Post, error)
+	// SetPostTags заменяет набор тегов поста; теги приходят уже нормализованными и без повторов.
+	// Версию поста не меняет: теги правятся вместе с UpdatePost.
+	SetPostTags(ctx context.Context, postID string, tags []string) error
+	BatchPostTags(ctx context.Context, postIDs []string) (map[string][]string, error)
+	// ListTags возвращает теги, у которых есть опубликованные посты: сначала популярные, при равенстве — по имени.
+	ListTags(ctx context.Context, limit int) ([]*model.Tag, error)
 
 	// Comments
 	CreateComment(ctx context.Context, comment *model.Comment) error
 	GetComment(ctx context.Context, id string) (*model.Comment, error)
-	ListComments(ctx context.Context, postID string, parentID *string, after *string, limit int) (*model.CommentPage, error)
+	// GetComments — пакетный GetComment для выдачи клиенту: удалённые комментарии приходят «надгробиями».
+	GetComments(ctx context.Context, ids []string) (map[string]*model.Comment, error)
+	ListComments(ctx context.Context, q CommentsQuery) (*model.CommentPage, error)
 	BatchCommentsCount(ctx context.Context, postIDs []string) (map[string]int, error)
+	// CommentTree возвращает ветку от rootID (или все корневые комментарии поста) на maxDepth уровней ниже,
+	// не больше maxNodes узлов; уровни заполняются по очереди, по времени создания.
+	CommentTree(ctx context.Context, postID string, rootID *string, maxDepth int, maxNodes int) ([]*model.CommentTreeNode, error)
+	// UpdateCommentBody заменяет текст комментария, сохраняя прежний в истории правок.
+	UpdateCommentBody(ctx context.Context, id string, body string, editedAt time.Time, expectedVersion *int) (*model.Comment, error)
+	ListCommentRevisions(ctx context.Context, commentID string) ([]*model.CommentRevision, error)
+	// DeleteComment превращает комментарий в «надгробие»: место в ветке и ответы сохраняются.
+	DeleteComment(ctx context.Context, id string, deletedAt time.Time, expectedVersion *int) (*model.Comment, error)
+	// LockComment закрывает (или открывает) для ответов ветку под комментарием.
+	LockComment(ctx context.Context, id string, locked bool, expectedVersion *int) (*model.Comment, error)
+
+	// Users. Пользователь заводится сам при первом посте или комментарии автора (в том числе при импорте).
+	// GetUsers возвращает найденных пользователей по id, см. UserID.
+	GetUsers(ctx context.Context, ids []string) (map[string]*model.User, error)
+	// EnsureUser возвращает пользователя name, заводя его, если он ещё ничего не писал.
+	EnsureUser(ctx context.Context, name string, at time.Time) (*model.User, error)
+	// SetUserRole назначает пользователю name роль, заводя его при необходимости.
+	SetUserRole(ctx context.Context, name string, role model.Role, at time.Time) (*model.User, error)
+
+	// API tokens
+	CreateAPIToken(ctx context.Context, t *model.APIToken) error
+	// GetAPITokenByHash ищет токен по хэшу, в том числе отозванный или истёкший.
+	GetAPITokenByHash(ctx context.Context, hash string) (*model.APIToken, error)
+	// ListAPITokens возвращает токены пользователя, новые первыми.
+	ListAPITokens(ctx context.Context, user string) ([]*model.APIToken, error)
+	// RevokeAPIToken отзывает токен пользователя user; чужой токен — ErrNotFound. Повторный отзыв ничего не меняет.
+	RevokeAPIToken(ctx context.Context, user string, id string, at time.Time) (*model.APIToken, error)
+
+	// Reactions; targetID — id поста или комментария
+	React(ctx context.Context, postID string, commentID *string, user string, kind model.ReactionKind, at time.Time) error
+	Unreact(ctx context.Context, targetID string, user string, kind model.ReactionKind) error
+	BatchReactions(ctx context.Context, targetIDs []string, viewer string) (map[string][]*model.ReactionCount, error)
+
+	// Export обходит все посты (вместе с черновиками), затем все комментарии так, что родитель идёт раньше ответа.
+	Export(ctx context.Context, fn func(Record) error) error
+	// Import сохраняет записи выгрузки как есть, с их id, createdAt и depth. Уже существующие записи пропускаются,
+	// поэтому прерванный импорт можно просто запустить заново. Возвращает число добавленных записей.
+	Import(ctx context.Context, records []Record) (int, error)
+
+	// Search ищет по заголовкам и текстам постов и текстам комментариев, лучшие совпадения первыми.
+	Search(ctx context.Context, query string, after *string, limit int) (*model.SearchPage, error)
+
+	// ClaimIdempotencyKey занимает ключ k.User/k.Key под мутацию k.Op. Если ключ уже есть, создан не раньше since
+	// и у него сохранён результат, ничего не меняет и возвращает его; иначе возвращает nil, и ключ за вызывающим.
+	// В PostgresStore параллельный вызов с тем же ключом ждёт, пока транзакция, занявшая ключ, не закончится.
+	ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (*IdempotencyKey, error)
+	// SetIdempotencyResult сохраняет результат мутации для занятого ключа.
+	SetIdempotencyResult(ctx context.Context, user string, key string, result []byte) error
+	// PurgeIdempotencyKeys удаляет ключи, созданные раньше before, и возвращает их число.
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int, error)
+
+	// WithTx выполняет fn атомарно: чтения и записи через tx не перемешиваются с параллельными мутациями.
+	// Ошибка fn возвращается как есть; оба хранилища при этом откатывают изменения fn.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+func (f PostFilter) match(p *model.Post) bool {
+	if p.Status != model.PostStatusPublished && (f.Viewer == nil || p.Author != *f.Viewer) {
+		return false
+	}
+	if f.Author != nil && p.Author != *f.Author {
+		return false
+	}
+	if f.Since != nil && feedTime(p).Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && !feedTime(p).Before(*f.Until) {
+		return false
+	}
+	if f.CommentsClosed != nil && p.CommentsClosed != *f.CommentsClosed {
+		return false
+	}
+	return true
+}
+
+// feedTime — время поста в ленте: момент публикации, а у черновика — момент создания.
+// По нему лента упорядочена, поэтому опубликованный позже черновик попадает в её начало.
+// В PostgreSQL ему соответствует coalesce(publish_at, created_at).
+func feedTime(p *model.Post) time.Time {
+	if p.PublishAt != nil {
+		return *p.PublishAt
+	}
+	return p.CreatedAt
+}
+
+// defaultPostStatus считает пост без статуса опубликованным в момент создания.
+func defaultPostStatus(p *model.Post) {
+	if p.Status == "" {
+		p.Status = model.PostStatusPublished
+	}
+	if p.Status == model.PostStatusPublished && p.PublishAt == nil {
+		at := p.CreatedAt
+		p.PublishAt = &at
+	}
+}
+
+// hideDeleted скрывает текст и автора удалённого комментария, не трогая сохранённую запись.
+func hideDeleted(c *model.Comment) *model.Comment {
+	if !c.Deleted {
+		return c
+	}
+	masked := *c
+	masked.Body = ""
+	masked.Author = ""
+	return &masked
+}
+
+// sortReactionCounts упорядочивает сводку в порядке объявления ReactionKind в схеме.
+func sortReactionCounts(counts []*model.ReactionCount) {
+	slices.SortFunc(counts, func(a, b *model.ReactionCount) int {
+		return slices.Index(model.AllReactionKind, a.Kind) - slices.Index(model.AllReactionKind, b.Kind)
+	})
+}
+
+// buildCommentTree собирает дерево из плоского списка, упорядоченного по уровням.
+// replies — сколько всего ответов у каждого комментария; узел помечается truncated, если часть не попала в выборку.
+func buildCommentTree(flat []*model.Comment, roots map[string]bool, replies map[string]int) []*model.CommentTreeNode {
+	nodes := make(map[string]*model.CommentTreeNode, len(flat))
+	out := []*model.CommentTreeNode{}
+	for _, c := range flat {
+		node := &model.CommentTreeNode{Comment: hideDeleted(c), Children: []*model.CommentTreeNode{}}
+		nodes[c.ID] = node
+		if roots[c.ID] {
+			out = append(out, node)
+			continue
+		}
+		if parent := nodes[*c.ParentID]; parent != nil {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	for id, node := range nodes {
+		node.Truncated = len(node.Children) < replies[id]
+	}
+	return out
 }
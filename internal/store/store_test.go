@@ -3,7 +3,11 @@ package store_test
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -37,6 +41,21 @@ func TestMemoryStore_CreateAndCountComments(t *testing.T) {
 	if p.CommentsCount != 2 {
 		t.Fatalf("expected commentsCount 2 got %d", p.CommentsCount)
 	}
+
+	// удалённый комментарий остаётся в ветке, но уходит из счётчика; повторные удаление и запись не считаются дважды
+	for range 2 {
+		if _, err := m.DeleteComment(ctx, "c1", time.Now().UTC(), nil); err != nil {
+			t.Fatalf("delete c1: %v", err)
+		}
+	}
+	_ = m.CreateComment(ctx, c2)
+	counts, err := m.BatchCommentsCount(ctx, []string{pid, "missing"})
+	if err != nil {
+		t.Fatalf("batch count: %v", err)
+	}
+	if counts[pid] != 1 || counts["missing"] != 0 {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
 }
 
 func TestMemoryStore_ListComments_PaginationCursor(t *testing.T) {
@@ -61,7 +80,7 @@ func TestMemoryStore_ListComments_PaginationCursor(t *testing.T) {
 	}
 
 	first := 2
-	conn, err := m.ListComments(ctx, pid, nil, nil, first)
+	conn, err := m.ListComments(ctx, store.CommentsQuery{PostID: pid, Order: model.CommentOrderOldest, Limit: first})
 	if err != nil {
 		t.Fatalf("list comments: %v", err)
 	}
@@ -73,7 +92,7 @@ func TestMemoryStore_ListComments_PaginationCursor(t *testing.T) {
 	}
 
 	// вторая страница
-	conn2, err := m.ListComments(ctx, pid, nil, conn.PageInfo.EndCursor, 10)
+	conn2, err := m.ListComments(ctx, store.CommentsQuery{PostID: pid, Order: model.CommentOrderOldest, After: conn.PageInfo.EndCursor, Limit: 10})
 	if err != nil {
 		t.Fatalf("list2: %v", err)
 	}
@@ -86,3 +105,618 @@ func TestMemoryStore_ListComments_PaginationCursor(t *testing.T) {
 		t.Fatal("endCursor decode empty")
 	}
 }
+
+func TestMemoryStore_ListPosts_KeysetAndFilter(t *testing.T) {
+	m := store.NewMemStore()
+	ctx := context.Background()
+
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		author := "alice"
+		if i%2 == 1 {
+			author = "bob"
+		}
+		p := &model.Post{
+			ID:        fmt.Sprintf("p%d", i),
+			Title:     "t",
+			Body:      "b",
+			Author:    author,
+			CreatedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := m.CreatePost(ctx, p); err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+
+	page, err := m.ListPosts(ctx, store.PostFilter{}, nil, 2)
+	if err != nil {
+		t.Fatalf("list posts: %v", err)
+	}
+	if len(page.Edges) != 2 || page.Edges[0].Node.ID != "p4" || page.Edges[1].Node.ID != "p3" {
+		t.Fatalf("unexpected first page: %+v", page.Edges)
+	}
+	if !page.PageInfo.HasNextPage {
+		t.Fatal("expected next page")
+	}
+
+	page, err = m.ListPosts(ctx, store.PostFilter{}, page.PageInfo.EndCursor, 3)
+	if err != nil {
+		t.Fatalf("list posts 2: %v", err)
+	}
+	if len(page.Edges) != 3 || page.Edges[2].Node.ID != "p0" {
+		t.Fatalf("unexpected second page: %+v", page.Edges)
+	}
+	if page.PageInfo.HasNextPage {
+		t.Fatal("exactly full last page must not report next page")
+	}
+
+	alice := "alice"
+	since := base.Add(time.Minute)
+	page, err = m.ListPosts(ctx, store.PostFilter{Author: &alice, Since: &since}, nil, 10)
+	if err != nil {
+		t.Fatalf("list filtered: %v", err)
+	}
+	if len(page.Edges) != 2 || page.Edges[0].Node.ID != "p4" || page.Edges[1].Node.ID != "p2" {
+		t.Fatalf("unexpected filtered page: %+v", page.Edges)
+	}
+
+	bad := "not-a-cursor"
+	if _, err := m.ListPosts(ctx, store.PostFilter{}, &bad, 10); err == nil {
+		t.Fatal("expected invalid cursor error")
+	}
+}
+
+func TestMemoryStore_CommentTree_Truncation(t *testing.T) {
+	m := store.NewMemStore()
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	_ = m.CreatePost(ctx, &model.Post{ID: "p", Title: "t", Body: "b", Author: "a", CreatedAt: base})
+	add := func(id string, parent *string, i int) {
+		c := &model.Comment{ID: id, PostID: "p", ParentID: parent, Body: id, Author: "u", CreatedAt: base.Add(time.Duration(i) * time.Second)}
+		if err := m.CreateComment(ctx, c); err != nil {
+			t.Fatalf("create %s: %v", id, err)
+		}
+	}
+	r1, r2, a, b := "r1", "r2", "a", "b"
+	add(r1, nil, 0)
+	add(r2, nil, 1)
+	add(a, &r1, 2)
+	add(b, &a, 3)
+	add("c", &r2, 4)
+
+	tree, err := m.CommentTree(ctx, "p", nil, 1, 100)
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	if len(tree) != 2 || tree[0].Comment.ID != "r1" || len(tree[0].Children) != 1 {
+		t.Fatalf("unexpected tree: %+v", tree)
+	}
+	// ответ b глубже maxDepth, поэтому ветка a помечена как обрезанная
+	if child := tree[0].Children[0]; child.Comment.ID != "a" || !child.Truncated || len(child.Children) != 0 {
+		t.Fatalf("expected truncated child a, got %+v", child)
+	}
+	if tree[0].Truncated {
+		t.Fatal("root r1 has all its replies and must not be truncated")
+	}
+
+	// лимит узлов: r1, r2 и только самый ранний ответ второго уровня
+	tree, err = m.CommentTree(ctx, "p", nil, 5, 3)
+	if err != nil {
+		t.Fatalf("tree: %v", err)
+	}
+	if len(tree[0].Children) != 1 || len(tree[1].Children) != 0 || !tree[1].Truncated {
+		t.Fatalf("unexpected node-limited tree: %+v %+v", tree[0], tree[1])
+	}
+
+	tree, err = m.CommentTree(ctx, "p", &a, 5, 100)
+	if err != nil {
+		t.Fatalf("subtree: %v", err)
+	}
+	if len(tree) != 1 || tree[0].Comment.ID != "a" || len(tree[0].Children) != 1 || tree[0].Children[0].Comment.ID != "b" {
+		t.Fatalf("unexpected subtree: %+v", tree)
+	}
+
+	missing := "nope"
+	if _, err := m.CommentTree(ctx, "p", &missing, 5, 100); err == nil {
+		t.Fatal("expected not found for unknown root")
+	}
+}
+
+func TestMemoryStore_ListPosts_PublishedDraftByPublishTime(t *testing.T) {
+	m := store.NewMemStore()
+	ctx := context.Background()
+
+	base := time.Now().UTC().Add(-time.Hour)
+	_ = m.CreatePost(ctx, &model.Post{ID: "draft", Author: "a", CreatedAt: base, Status: model.PostStatusDraft})
+	_ = m.CreatePost(ctx, &model.Post{ID: "p1", Author: "a", CreatedAt: base.Add(time.Minute)})
+	_ = m.CreatePost(ctx, &model.Post{ID: "p2", Author: "a", CreatedAt: base.Add(2 * time.Minute)})
+
+	// черновик создан раньше всех, но опубликован позже, поэтому в ленте он первый
+	at := base.Add(3 * time.Minute)
+	if _, err := m.SetPostStatus(ctx, "draft", model.PostStatusPublished, &at, nil); err != nil {
+		t.Fatalf("publish draft: %v", err)
+	}
+
+	page, err := m.ListPosts(ctx, store.PostFilter{}, nil, 1)
+	if err != nil || len(page.Edges) != 1 || page.Edges[0].Node.ID != "draft" {
+		t.Fatalf("published draft must lead the feed: %+v %v", page, err)
+	}
+	page, err = m.ListPosts(ctx, store.PostFilter{}, page.PageInfo.EndCursor, 10)
+	if err != nil || len(page.Edges) != 2 || page.Edges[0].Node.ID != "p2" || page.Edges[1].Node.ID != "p1" {
+		t.Fatalf("unexpected page after published draft: %+v %v", page, err)
+	}
+
+	since := base.Add(150 * time.Second)
+	page, _ = m.ListPosts(ctx, store.PostFilter{Since: &since}, nil, 10)
+	if len(page.Edges) != 1 || page.Edges[0].Node.ID != "draft" {
+		t.Fatalf("since must compare publish time: %+v", page.Edges)
+	}
+}
+
+func TestMemoryStore_PublishDue(t *testing.T) {
+	st := store.NewMemStore()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	due, later := now.Add(-time.Minute), now.Add(time.Hour)
+	_ = st.CreatePost(ctx, &model.Post{ID: "due", Author: "a", CreatedAt: now, Status: model.PostStatusScheduled, PublishAt: &due})
+	_ = st.CreatePost(ctx, &model.Post{ID: "later", Author: "a", CreatedAt: now, Status: model.PostStatusScheduled, PublishAt: &later})
+	_ = st.CreatePost(ctx, &model.Post{ID: "draft", Author: "a", CreatedAt: now, Status: model.PostStatusDraft})
+
+	published, err := st.PublishDue(ctx, now)
+	if err != nil {
+		t.Fatalf("publish due: %v", err)
+	}
+	if len(published) != 1 || published[0].ID != "due" || published[0].Status != model.PostStatusPublished {
+		t.Fatalf("expected only the due post to be published, got %+v", published)
+	}
+
+	page, err := st.ListPosts(ctx, store.PostFilter{}, nil, 10)
+	if err != nil || len(page.Edges) != 1 {
+		t.Fatalf("expected only published posts in the feed, got %v %v", page, err)
+	}
+	author := "a"
+	page, _ = st.ListPosts(ctx, store.PostFilter{Viewer: &author}, nil, 10)
+	if len(page.Edges) != 3 {
+		t.Fatalf("expected author to see all own posts, got %d", len(page.Edges))
+	}
+}
+
+func TestMemoryStore_PersistsAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	st, err := store.OpenMemStore(dir, 3)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	parentID := "c1"
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "hello", Body: "b", Author: "a", CreatedAt: now})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: "first", Author: "u", CreatedAt: now})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c2", PostID: "p1", ParentID: &parentID, Body: "reply", Author: "u", CreatedAt: now})
+	// третья запись сняла снимок, дальше — только журнал
+	if _, err := st.UpdateCommentBody(ctx, "c1", "edited", now.Add(time.Second), nil); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	_ = st.React(ctx, "p1", nil, "u", model.ReactionKindHeart, now)
+	_ = st.SetPostTags(ctx, "p1", []string{"go"})
+	_ = st.CreateAPIToken(ctx, &model.APIToken{ID: "t1", User: "u", Name: "bot", Hash: "h", Scopes: []string{"moderate"}, CreatedAt: now})
+	_, _ = st.RevokeAPIToken(ctx, "u", "t1", now)
+	_, _ = st.SetUserRole(ctx, "u", model.RoleModerator, now)
+	_, _ = st.LockComment(ctx, "c1", true, nil)
+
+	// без Close, как после падения: снимок плюс журнал
+	check := func(st store.Store) {
+		t.Helper()
+		p, err := st.GetPost(ctx, "p1")
+		if err != nil || p.Title != "hello" || p.CommentsCount != 2 {
+			t.Fatalf("unexpected post after restart: %+v %v", p, err)
+		}
+		c, err := st.GetComment(ctx, "c2")
+		if err != nil || c.Depth != 1 {
+			t.Fatalf("unexpected reply after restart: %+v %v", c, err)
+		}
+		if c, _ := st.GetComment(ctx, "c1"); c == nil || !c.Locked {
+			t.Fatalf("expected locked thread after restart: %+v", c)
+		}
+		revs, _ := st.ListCommentRevisions(ctx, "c1")
+		if len(revs) != 1 || revs[0].Body != "first" {
+			t.Fatalf("expected exactly one revision, got %+v", revs)
+		}
+		reactions, _ := st.BatchReactions(ctx, []string{"p1"}, "u")
+		if len(reactions["p1"]) != 1 || !reactions["p1"][0].ViewerHasReacted {
+			t.Fatalf("unexpected reactions: %+v", reactions["p1"])
+		}
+		tags, _ := st.BatchPostTags(ctx, []string{"p1"})
+		if len(tags["p1"]) != 1 {
+			t.Fatalf("unexpected tags: %v", tags["p1"])
+		}
+		hits, _ := st.Search(ctx, "edited", nil, 10)
+		if len(hits.Edges) != 1 {
+			t.Fatalf("expected search index to be rebuilt, got %d hits", len(hits.Edges))
+		}
+		users, _ := st.GetUsers(ctx, []string{store.UserID("a"), store.UserID("u")})
+		if len(users) != 2 || users[store.UserID("u")].Role != model.RoleModerator || users[store.UserID("a")].Role != model.RoleUser {
+			t.Fatalf("expected authors as users, got %+v", users)
+		}
+		tok, err := st.GetAPITokenByHash(ctx, "h")
+		if err != nil || tok.RevokedAt == nil || len(tok.Scopes) != 1 {
+			t.Fatalf("expected revoked api token, got %+v %v", tok, err)
+		}
+	}
+
+	crashed, err := store.OpenMemStore(dir, 3)
+	if err != nil {
+		t.Fatalf("reopen after crash: %v", err)
+	}
+	check(crashed)
+
+	if err := st.(io.Closer).Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "wal.log")); err != nil || info.Size() != 0 {
+		t.Fatalf("expected compacted wal, got %v %v", info, err)
+	}
+	reopened, err := store.OpenMemStore(dir, 3)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(reopened)
+}
+
+func TestMemoryStore_IgnoresTornWalTail(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	st, _ := store.OpenMemStore(dir, 100)
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "t", Body: "b", Author: "a", CreatedAt: time.Now().UTC()})
+
+	f, err := os.OpenFile(filepath.Join(dir, "wal.log"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	_, _ = f.WriteString(`{"seq":2,"op":"deletePost","id":"p`)
+	_ = f.Close()
+
+	reopened, err := store.OpenMemStore(dir, 100)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := reopened.GetPost(ctx, "p1"); err != nil {
+		t.Fatalf("expected post to survive a torn record: %v", err)
+	}
+}
+
+func TestMemoryStore_ExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	src := store.NewMemStore()
+	parentID := "c1"
+	_ = src.CreatePost(ctx, &model.Post{ID: "p1", Title: "hello", Body: "b", Author: "a", CreatedAt: now})
+	_ = src.SetPostTags(ctx, "p1", []string{"go"})
+	_ = src.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: "first", Author: "u", CreatedAt: now})
+	_ = src.CreateComment(ctx, &model.Comment{ID: "c2", PostID: "p1", ParentID: &parentID, Body: "reply", Author: "u", CreatedAt: now.Add(time.Second)})
+	_, _ = src.DeleteComment(ctx, "c1", now.Add(2*time.Second), nil)
+
+	var records []store.Record
+	err := src.Export(ctx, func(rec store.Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil || len(records) != 3 {
+		t.Fatalf("unexpected export: %d records, %v", len(records), err)
+	}
+
+	dst := store.NewMemStore()
+	added, err := dst.Import(ctx, records)
+	if err != nil || added != 3 {
+		t.Fatalf("unexpected import: added %d, %v", added, err)
+	}
+	p, err := dst.GetPost(ctx, "p1")
+	if err != nil || p.CommentsCount != 1 {
+		t.Fatalf("unexpected post after import: %+v %v", p, err)
+	}
+	tags, _ := dst.BatchPostTags(ctx, []string{"p1"})
+	if len(tags["p1"]) != 1 || tags["p1"][0] != "go" {
+		t.Fatalf("unexpected tags: %+v", tags["p1"])
+	}
+	c, err := dst.GetComment(ctx, "c2")
+	if err != nil || c.Depth != 1 || c.ParentID == nil || *c.ParentID != "c1" {
+		t.Fatalf("unexpected reply after import: %+v %v", c, err)
+	}
+	if c, _ := dst.GetComment(ctx, "c1"); c == nil || !c.Deleted || c.DeletedAt == nil || !c.DeletedAt.Equal(now.Add(2*time.Second)) {
+		t.Fatalf("deleted comment must stay a tombstone with its deletion time: %+v", c)
+	}
+
+	// повторный импорт того же файла ничего не добавляет
+	added, err = dst.Import(ctx, records)
+	if err != nil || added != 0 {
+		t.Fatalf("expected idempotent import, added %d, %v", added, err)
+	}
+}
+
+func TestMemoryStore_WithTxBlocksConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemStore()
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "t", Body: "b", Author: "a", CreatedAt: time.Now()})
+
+	closed := make(chan struct{})
+	err := st.WithTx(ctx, func(tx store.Store) error {
+		go func() {
+			_, _ = st.CloseComments(ctx, "p1", true, nil)
+			close(closed)
+		}()
+
+		p, err := tx.GetPost(ctx, "p1")
+		if err != nil {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+		select {
+		case <-closed:
+			t.Fatal("concurrent write must wait for the transaction")
+		default:
+		}
+		if p.CommentsClosed {
+			t.Fatal("comments closed inside the transaction")
+		}
+		return tx.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: "x", Author: "u", CreatedAt: time.Now()})
+	})
+	if err != nil {
+		t.Fatalf("tx: %v", err)
+	}
+
+	<-closed
+	p, _ := st.GetPost(ctx, "p1")
+	if !p.CommentsClosed || p.CommentsCount != 1 {
+		t.Fatalf("unexpected post after tx: %+v", p)
+	}
+}
+
+func TestMemoryStore_WithTxRollsBackOnError(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	st, err := store.OpenMemStore(dir, 100)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "kept", Body: "b", Author: "a", CreatedAt: now})
+
+	// запись, правка и ошибка в одной транзакции: как в PostgreSQL, не остаётся ни того, ни другого
+	failed := errors.New("tags failed")
+	err = st.WithTx(ctx, func(tx store.Store) error {
+		if err := tx.CreatePost(ctx, &model.Post{ID: "p2", Title: "rolled back", Body: "b", Author: "new", CreatedAt: now}); err != nil {
+			return err
+		}
+		if err := tx.SetPostTags(ctx, "p2", []string{"go"}); err != nil {
+			return err
+		}
+		if err := tx.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: "rolled back", Author: "u", CreatedAt: now}); err != nil {
+			return err
+		}
+		if _, err := tx.CloseComments(ctx, "p1", true, nil); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+
+	check := func(st store.Store) {
+		t.Helper()
+		if _, err := st.GetPost(ctx, "p2"); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("post created in failed tx must be gone, got %v", err)
+		}
+		if _, err := st.GetComment(ctx, "c1"); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("comment created in failed tx must be gone, got %v", err)
+		}
+		p, err := st.GetPost(ctx, "p1")
+		if err != nil || p.CommentsClosed || p.CommentsCount != 0 || p.Version != 1 {
+			t.Fatalf("post changed by failed tx: %+v %v", p, err)
+		}
+		page, _ := st.ListPosts(ctx, store.PostFilter{}, nil, 10)
+		if len(page.Edges) != 1 {
+			t.Fatalf("expected only p1 in feed, got %d posts", len(page.Edges))
+		}
+		if hits, _ := st.Search(ctx, "rolled", nil, 10); len(hits.Edges) != 0 {
+			t.Fatalf("search index must not keep rolled back writes, got %d hits", len(hits.Edges))
+		}
+		tags, _ := st.ListTags(ctx, 10)
+		users, _ := st.GetUsers(ctx, []string{store.UserID("new")})
+		if len(tags) != 0 || len(users) != 0 {
+			t.Fatalf("tags or users left by failed tx: %+v %+v", tags, users)
+		}
+	}
+	check(st)
+
+	// откатанные записи не попали в журнал
+	reopened, err := store.OpenMemStore(dir, 100)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	check(reopened)
+}
+
+func TestMemoryStore_WithTxCompactsAfterWholeBatch(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// третья запись журнала приходится на середину транзакции: снимок должен сниматься только после всей пачки
+	st, err := store.OpenMemStore(dir, 3)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "t", Body: "b", Author: "a", CreatedAt: now})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: "first", Author: "u", CreatedAt: now})
+	err = st.WithTx(ctx, func(tx store.Store) error {
+		if _, err := tx.CloseComments(ctx, "p1", true, nil); err != nil {
+			return err
+		}
+		_, err := tx.UpdateCommentBody(ctx, "c1", "second", now.Add(time.Second), nil)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("tx: %v", err)
+	}
+
+	// без Close: состояние собирается из снимка и хвоста журнала
+	reopened, err := store.OpenMemStore(dir, 3)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	c, err := reopened.GetComment(ctx, "c1")
+	if err != nil || c.Body != "second" || c.Version != 2 {
+		t.Fatalf("transaction must be applied once: %+v %v", c, err)
+	}
+	revs, _ := reopened.ListCommentRevisions(ctx, "c1")
+	if len(revs) != 1 {
+		t.Fatalf("expected 1 revision, got %d", len(revs))
+	}
+	if p, _ := reopened.GetPost(ctx, "p1"); p == nil || !p.CommentsClosed || p.Version != 2 {
+		t.Fatalf("unexpected post after reopen: %+v", p)
+	}
+}
+
+func TestMemoryStore_WithTxRollbackRestoresIndexes(t *testing.T) {
+	st := store.NewMemStore()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	parentID := "c1"
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "t", Body: "b", Author: "a", CreatedAt: now})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: "alpha", Author: "u", CreatedAt: now})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c2", PostID: "p1", ParentID: &parentID, Body: "reply", Author: "u", CreatedAt: now.Add(time.Second)})
+
+	// правка и удаление поста вместе с веткой, затем ошибка: лента, ветки и поиск возвращаются к прежним записям
+	failed := errors.New("failed")
+	err := st.WithTx(ctx, func(tx store.Store) error {
+		if _, err := tx.UpdateCommentBody(ctx, "c1", "beta", now, nil); err != nil {
+			return err
+		}
+		if err := tx.DeletePost(ctx, "p1", nil); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected fn error, got %v", err)
+	}
+
+	if page, _ := st.ListPosts(ctx, store.PostFilter{}, nil, 10); len(page.Edges) != 1 {
+		t.Fatalf("post must be back in the feed, got %+v", page.Edges)
+	}
+	tree, err := st.CommentTree(ctx, "p1", nil, 5, 10)
+	if err != nil || len(tree) != 1 || tree[0].Comment.Body != "alpha" || len(tree[0].Children) != 1 {
+		t.Fatalf("thread must be restored: %+v %v", tree, err)
+	}
+	if hits, _ := st.Search(ctx, "alpha", nil, 10); len(hits.Edges) != 1 {
+		t.Fatalf("restored comment must be searchable, got %d hits", len(hits.Edges))
+	}
+	if hits, _ := st.Search(ctx, "beta", nil, 10); len(hits.Edges) != 0 {
+		t.Fatalf("rolled back body must not be searchable, got %d hits", len(hits.Edges))
+	}
+}
+
+func TestMemoryStore_IdempotencyKeys(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	st, err := store.OpenMemStore(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	k := store.IdempotencyKey{User: "u", Key: "k", Op: "createPost", CreatedAt: now}
+	if prev, err := st.ClaimIdempotencyKey(ctx, k, now.Add(-time.Hour)); prev != nil || err != nil {
+		t.Fatalf("first claim: %+v %v", prev, err)
+	}
+	// пока результата нет, ключ можно занять снова: предыдущая попытка не дошла до конца
+	if prev, _ := st.ClaimIdempotencyKey(ctx, k, now.Add(-time.Hour)); prev != nil {
+		t.Fatalf("key without result must be reclaimable: %+v", prev)
+	}
+	_ = st.SetIdempotencyResult(ctx, "u", "k", []byte(`{"id":"p1"}`))
+
+	// результат переживает перезапуск
+	st, err = store.OpenMemStore(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	prev, err := st.ClaimIdempotencyKey(ctx, k, now.Add(-time.Hour))
+	if err != nil || prev == nil || string(prev.Result) != `{"id":"p1"}` {
+		t.Fatalf("expected saved result, got %+v %v", prev, err)
+	}
+	// истёкший ключ занимается заново
+	if prev, _ := st.ClaimIdempotencyKey(ctx, k, now.Add(time.Second)); prev != nil {
+		t.Fatalf("expired key must be reclaimable: %+v", prev)
+	}
+
+	if n, err := st.PurgeIdempotencyKeys(ctx, now.Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("purge: %d %v", n, err)
+	}
+}
+
+func TestMemoryStore_Search(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemStore()
+	now := time.Now().UTC()
+
+	_ = st.CreatePost(ctx, &model.Post{ID: "p1", Title: "Go generics", Body: "type parameters in go", Author: "a", CreatedAt: now})
+	_ = st.CreatePost(ctx, &model.Post{ID: "p2", Title: "Rust", Body: "ownership, not go", Author: "a", CreatedAt: now})
+	_ = st.CreatePost(ctx, &model.Post{ID: "p3", Title: "Go draft", Body: "b", Author: "a", CreatedAt: now, Status: model.PostStatusDraft})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c1", PostID: "p1", Body: `<img src=x onerror=alert(1)> go "generics"`, Author: "u", CreatedAt: now})
+	_ = st.CreateComment(ctx, &model.Comment{ID: "c2", PostID: "p1", Body: "go generics, deleted later", Author: "u", CreatedAt: now})
+	_, _ = st.DeleteComment(ctx, "c2", now, nil)
+
+	// все слова запроса должны встретиться в документе; черновики и удалённые комментарии не ищутся
+	page, err := st.Search(ctx, "GO generics", nil, 10)
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(page.Edges) != 2 || page.Edges[0].Node.Post.ID != "p1" || page.Edges[0].Node.Kind != model.SearchHitKindPost {
+		t.Fatalf("expected p1 first and c1, got %+v", page.Edges)
+	}
+
+	post := page.Edges[0].Node.Snippet
+	if post != "<b>Go</b> <b>generics</b> type parameters in <b>go</b>" {
+		t.Fatalf("unexpected post snippet %q", post)
+	}
+	// текст вокруг меток экранируется: фрагмент отдаётся клиенту как HTML
+	comment := page.Edges[1].Node.Snippet
+	want := `&lt;img src=x onerror=alert(1)&gt; <b>go</b> &#34;<b>generics</b>&#34;`
+	if comment != want {
+		t.Fatalf("unexpected comment snippet\n got %q\nwant %q", comment, want)
+	}
+
+	// курсор — смещение в выдаче: вторая страница продолжает первую
+	first, _ := st.Search(ctx, "go", nil, 2)
+	if len(first.Edges) != 2 || !first.PageInfo.HasNextPage || first.PageInfo.HasPreviousPage {
+		t.Fatalf("unexpected first page: %+v", first.PageInfo)
+	}
+	second, err := st.Search(ctx, "go", first.PageInfo.EndCursor, 2)
+	if err != nil || len(second.Edges) != 1 || second.PageInfo.HasNextPage || !second.PageInfo.HasPreviousPage {
+		t.Fatalf("unexpected second page: %+v %v", second, err)
+	}
+	seen := map[string]bool{}
+	for _, e := range append(first.Edges, second.Edges...) {
+		key := string(e.Node.Kind) + e.Node.Post.ID
+		if e.Node.Comment != nil {
+			key = string(e.Node.Kind) + e.Node.Comment.ID
+		}
+		if seen[key] {
+			t.Fatalf("hit %s repeated across pages", key)
+		}
+		seen[key] = true
+	}
+
+	bad := "not-a-cursor"
+	if _, err := st.Search(ctx, "go", &bad, 2); err != store.ErrInvalidCursor {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package store
+
+import (
+	"crypto/md5"
+
+	"github.com/google/uuid"
+)
+
+// UserID возвращает id пользователя с именем name. Он вычисляется из имени (md5 в виде uuid, как md5(name)::uuid
+// в PostgreSQL), поэтому одинаков во всех хранилищах, после экспорта-импорта и в миграции, заполнившей users.
+func UserID(name string) string {
+	return uuid.UUID(md5.Sum([]byte(name))).String()
+}
@@ -0,0 +1,2 @@
+drop table if exists comments;
+drop table if exists posts;
@@ -0,0 +1,3 @@
+-- не пройдёт, если в базе есть корневые комментарии с пустым parent_id
+ALTER TABLE comments
+    ALTER COLUMN parent_id SET NOT NULL;
@@ -0,0 +1,2 @@
+drop index if exists idx_posts_author_time_id;
+drop index if exists idx_posts_time_id;
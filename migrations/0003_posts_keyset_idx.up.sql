@@ -0,0 +1,5 @@
+create index if not exists idx_posts_time_id
+    on posts (created_at desc, id desc);
+
+create index if not exists idx_posts_author_time_id
+    on posts (author, created_at desc, id desc);
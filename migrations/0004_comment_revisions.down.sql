@@ -0,0 +1,4 @@
+drop table if exists comment_revisions;
+
+alter table comments
+    drop column if exists edited_at;
@@ -0,0 +1,13 @@
+alter table comments
+    add column if not exists edited_at timestamptz;
+
+create table if not exists comment_revisions
+(
+    id         bigserial primary key,
+    comment_id uuid        not null references comments (id) on delete cascade,
+    body       text        not null,
+    created_at timestamptz not null
+);
+
+create index if not exists idx_comment_revisions_comment_id_time
+    on comment_revisions (comment_id, created_at, id);
@@ -0,0 +1,2 @@
+alter table comments
+    add column if not exists deleted_at timestamptz;
@@ -0,0 +1,8 @@
+drop index if exists idx_comments_search_vector;
+drop index if exists idx_posts_search_vector;
+
+alter table comments
+    drop column if exists search_vector;
+
+alter table posts
+    drop column if exists search_vector;
@@ -0,0 +1,16 @@
+alter table posts
+    add column if not exists search_vector tsvector
+        generated always as (
+            setweight(to_tsvector('simple', title), 'A') ||
+            setweight(to_tsvector('simple', body), 'B')
+        ) stored;
+
+alter table comments
+    add column if not exists search_vector tsvector
+        generated always as (to_tsvector('simple', body)) stored;
+
+create index if not exists idx_posts_search_vector
+    on posts using gin (search_vector);
+
+create index if not exists idx_comments_search_vector
+    on comments using gin (search_vector);
@@ -0,0 +1,16 @@
+create table if not exists reactions
+(
+    target_id  uuid        not null, -- id поста или комментария
+    post_id    uuid        not null references posts (id) on delete cascade,
+    comment_id uuid references comments (id) on delete cascade,
+    user_name  text        not null,
+    kind       text        not null check ( kind in ('THUMBS_UP', 'THUMBS_DOWN', 'HEART', 'LAUGH', 'SURPRISED', 'SAD') ),
+    created_at timestamptz not null,
+    primary key (target_id, user_name, kind)
+);
+
+create index if not exists idx_reactions_post_id
+    on reactions (post_id);
+
+create index if not exists idx_reactions_comment_id
+    on reactions (comment_id);
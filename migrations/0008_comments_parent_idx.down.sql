@@ -0,0 +1 @@
+drop index if exists idx_comments_parent_id_time_id;
@@ -0,0 +1,2 @@
+create index if not exists idx_comments_parent_id_time_id
+    on comments (parent_id, created_at, id);
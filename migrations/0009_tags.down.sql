@@ -0,0 +1,2 @@
+drop table if exists post_tags;
+drop table if exists tags;
@@ -0,0 +1,15 @@
+create table if not exists tags
+(
+    id   bigserial primary key,
+    name text not null unique
+);
+
+create table if not exists post_tags
+(
+    post_id uuid   not null references posts (id) on delete cascade,
+    tag_id  bigint not null references tags (id) on delete cascade,
+    primary key (post_id, tag_id)
+);
+
+create index if not exists idx_post_tags_tag_id
+    on post_tags (tag_id, post_id);
@@ -0,0 +1,5 @@
+drop index if exists idx_posts_scheduled_publish_at;
+
+alter table posts
+    drop column if exists publish_at,
+    drop column if exists status;
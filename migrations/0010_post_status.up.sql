@@ -0,0 +1,11 @@
+alter table posts
+    add column if not exists status     text not null default 'PUBLISHED'
+        check ( status in ('DRAFT', 'SCHEDULED', 'PUBLISHED') ),
+    add column if not exists publish_at timestamptz;
+
+-- уже существующие посты опубликованы в момент создания
+update posts set publish_at = created_at where publish_at is null and status = 'PUBLISHED';
+
+-- фоновый публикатор ищет только отложенные посты
+create index if not exists idx_posts_scheduled_publish_at
+    on posts (publish_at) where status = 'SCHEDULED';
@@ -0,0 +1,2 @@
+alter table posts
+    drop column if exists comments_count;
@@ -0,0 +1,8 @@
+alter table posts
+    add column if not exists comments_count int not null default 0;
+
+-- разовое заполнение счётчика; дальше его поддерживает CreateComment в той же транзакции
+update posts p
+set comments_count = c.cnt
+from (select post_id, count(*) as cnt from comments group by post_id) c
+where c.post_id = p.id;
@@ -0,0 +1,2 @@
+alter table comments
+    drop column if exists reply_to_id;
@@ -0,0 +1,5 @@
+-- при ограничении глубины ответ может попасть не под тот комментарий, на который отвечали
+alter table comments
+    add column if not exists reply_to_id uuid references comments (id) on delete set null;
+
+update comments set reply_to_id = parent_id where reply_to_id is null and parent_id is not null;
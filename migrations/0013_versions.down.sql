@@ -0,0 +1,5 @@
+alter table comments
+    drop column if exists version;
+
+alter table posts
+    drop column if exists version;
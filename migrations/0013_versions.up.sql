@@ -0,0 +1,6 @@
+-- версия для оптимистичных блокировок: растёт на каждой правке строки
+alter table posts
+    add column if not exists version int not null default 1;
+
+alter table comments
+    add column if not exists version int not null default 1;
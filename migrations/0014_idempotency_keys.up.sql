@@ -0,0 +1,13 @@
+-- результаты мутаций по ключу идемпотентности; result пуст, пока мутация не завершилась
+create table if not exists idempotency_keys
+(
+    user_name  text        not null,
+    key        text        not null,
+    op         text        not null,
+    result     jsonb,
+    created_at timestamptz not null,
+    primary key (user_name, key)
+);
+
+create index if not exists idx_idempotency_keys_created_at
+    on idempotency_keys (created_at);
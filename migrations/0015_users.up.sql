@@ -0,0 +1,17 @@
+-- id пользователя — md5 от имени, приведённый к uuid: так его без таблицы вычисляет и приложение (store.UserID)
+create table if not exists users
+(
+    id         uuid primary key,
+    name       text        not null unique,
+    created_at timestamptz not null
+);
+
+-- авторы уже существующих постов и комментариев; createdAt — их первое появление
+insert into users (id, name, created_at)
+select md5(author)::uuid, author, min(created_at)
+from (select author, created_at from posts
+      union all
+      select author, created_at from comments) a
+where author <> ''
+group by author
+on conflict do nothing;
@@ -0,0 +1,15 @@
+-- личные токены; хранится только sha256 токена
+create table if not exists api_tokens
+(
+    id         uuid primary key,
+    user_name  text        not null,
+    name       text        not null,
+    token_hash text        not null unique,
+    scopes     text[]      not null,
+    created_at timestamptz not null,
+    expires_at timestamptz,
+    revoked_at timestamptz
+);
+
+create index if not exists idx_api_tokens_user_name
+    on api_tokens (user_name, created_at desc);
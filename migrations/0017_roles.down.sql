@@ -0,0 +1,5 @@
+alter table comments
+    drop column if exists locked;
+
+alter table users
+    drop column if exists role;
@@ -0,0 +1,7 @@
+-- роли пользователей и блокировка веток модераторами
+alter table users
+    add column if not exists role text not null default 'USER'
+        check (role in ('USER', 'MODERATOR', 'ADMIN'));
+
+alter table comments
+    add column if not exists locked boolean not null default false;
@@ -0,0 +1,2 @@
+update posts p
+set comments_count = (select count(*) from comments c where c.post_id = p.id);
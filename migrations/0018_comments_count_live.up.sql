@@ -0,0 +1,3 @@
+-- удалённые комментарии больше не входят в comments_count: DeleteComment уменьшает счётчик в той же транзакции
+update posts p
+set comments_count = (select count(*) from comments c where c.post_id = p.id and c.deleted_at is null);
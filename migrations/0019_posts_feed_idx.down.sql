@@ -0,0 +1,8 @@
+drop index if exists idx_posts_author_feed_time_id;
+drop index if exists idx_posts_feed_time_id;
+
+create index if not exists idx_posts_time_id
+    on posts (created_at desc, id desc);
+
+create index if not exists idx_posts_author_time_id
+    on posts (author, created_at desc, id desc);
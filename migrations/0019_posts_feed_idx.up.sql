@@ -0,0 +1,9 @@
+-- лента упорядочена по времени публикации, а у черновиков — по времени создания
+drop index if exists idx_posts_time_id;
+drop index if exists idx_posts_author_time_id;
+
+create index if not exists idx_posts_feed_time_id
+    on posts (coalesce(publish_at, created_at) desc, id desc);
+
+create index if not exists idx_posts_author_feed_time_id
+    on posts (author, coalesce(publish_at, created_at) desc, id desc);
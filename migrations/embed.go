@@ -0,0 +1,9 @@
+// Package migrations встраивает SQL-миграции схемы PostgreSQL в бинарник.
+package migrations
+
+import "embed"
+
+// FS содержит пары NNNN_name.up.sql / NNNN_name.down.sql.
+//
+//go:embed *.sql
+var FS embed.FS